@@ -0,0 +1,104 @@
+// Package bindletest provides an in-process fake Bindle server for testing code that uses the
+// Bindle client, without needing a `bindle-server` binary. The server is a `server.Server` using
+// in-memory storage and can inject faults such as latency, error responses and truncated bodies
+package bindletest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/keyring"
+	"github.com/deislabs/go-bindle/server"
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// APIPrefix is the path the Bindle API is served under, like the real bindle-server
+const APIPrefix = "/v1"
+
+// HostKeyLabel is the label of the key the server uses to sign invoices
+const HostKeyLabel = "bindletest host <host@bindletest.invalid>"
+
+// Server is a fake Bindle server. Its state only lives in memory, so every server starts out empty
+type Server struct {
+	// URL is the base URL of the Bindle API, suitable for passing to `client.New`
+	URL string
+	// HostKey is the public key the server signs every created invoice with. It is also served from
+	// the bindle-keys endpoint
+	HostKey *types.SignatureKey
+
+	server *httptest.Server
+	faults *faultInjector
+}
+
+// NewServer starts a new fake Bindle server using plain HTTP. The caller should call `Close` when
+// finished to shut it down
+func NewServer() (*Server, error) {
+	s, err := newServer()
+	if err != nil {
+		return nil, err
+	}
+	s.server.Start()
+	s.URL = s.server.URL + APIPrefix
+	return s, nil
+}
+
+// NewTLSServer starts a new fake Bindle server using TLS and HTTP/2, like a real Bindle server.
+// Use the `Client` function to get a client that trusts the server's certificate
+func NewTLSServer() (*Server, error) {
+	s, err := newServer()
+	if err != nil {
+		return nil, err
+	}
+	s.server.EnableHTTP2 = true
+	s.server.StartTLS()
+	s.URL = s.server.URL + APIPrefix
+	return s, nil
+}
+
+func newServer() (*Server, error) {
+	hostKey, hostPriv, err := keyring.GenerateSignatureKey(HostKeyLabel, types.RoleHost)
+	if err != nil {
+		return nil, err
+	}
+
+	h := server.New(storage.NewMemoryProvider(), server.WithHostKey(hostKey, hostPriv))
+	faults := &faultInjector{next: http.StripPrefix(APIPrefix, h)}
+
+	return &Server{
+		HostKey: hostKey,
+		server:  httptest.NewUnstartedServer(faults),
+		faults:  faults,
+	}, nil
+}
+
+// Client returns a new Bindle client configured to talk to the server, with any additional options
+func (s *Server) Client(opts ...client.Option) (*client.Client, error) {
+	var tlsConfig *tls.Config
+	if cert := s.server.Certificate(); cert != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(cert)
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return client.New(s.URL, tlsConfig, opts...)
+}
+
+// HostKeyring returns a keyring containing the server's host key
+func (s *Server) HostKeyring() *types.Keyring {
+	return &types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*s.HostKey}}
+}
+
+// SetFaults replaces the faults the server injects into responses. Pass the zero value to go back
+// to normal behavior
+func (s *Server) SetFaults(faults Faults) {
+	s.faults.set(faults)
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
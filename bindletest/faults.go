@@ -0,0 +1,95 @@
+package bindletest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Faults configures failures the server injects into its responses, to test how code using the
+// client behaves with slow or misbehaving servers. The zero value injects no faults
+type Faults struct {
+	// Latency is added before every response
+	Latency time.Duration
+	// ErrorStatus, if set, is returned (with an error body) instead of handling requests. This is
+	// generally a 5xx status code
+	ErrorStatus int
+	// ErrorCount limits the number of requests that fail with ErrorStatus, after which requests are
+	// handled normally again. If 0, every request fails
+	ErrorCount int
+	// TruncateBodies cuts off every successful response body halfway through, while still
+	// advertising the full Content-Length
+	TruncateBodies bool
+}
+
+// faultInjector wraps a handler and applies the configured faults
+type faultInjector struct {
+	lock   sync.Mutex
+	faults Faults
+	// the number of errors injected since the faults were set
+	errors int
+
+	next http.Handler
+}
+
+func (f *faultInjector) set(faults Faults) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.faults = faults
+	f.errors = 0
+}
+
+// shouldFail returns true if the current request should fail with the error status
+func (f *faultInjector) shouldFail() (Faults, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.faults.ErrorStatus == 0 {
+		return f.faults, false
+	}
+	if f.faults.ErrorCount > 0 && f.errors >= f.faults.ErrorCount {
+		return f.faults, false
+	}
+	f.errors++
+	return f.faults, true
+}
+
+func (f *faultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	faults, fail := f.shouldFail()
+
+	if faults.Latency > 0 {
+		select {
+		case <-time.After(faults.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if fail {
+		w.Header().Set("Content-Type", "application/toml")
+		w.WriteHeader(faults.ErrorStatus)
+		w.Write([]byte("error = \"injected fault\"\n"))
+		return
+	}
+
+	if !faults.TruncateBodies {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	recorder := httptest.NewRecorder()
+	f.next.ServeHTTP(recorder, r)
+	for k, v := range recorder.Header() {
+		w.Header()[k] = v
+	}
+	body := recorder.Body.Bytes()
+	if recorder.Code < 200 || recorder.Code > 299 {
+		w.WriteHeader(recorder.Code)
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(recorder.Code)
+	w.Write(body[:len(body)/2])
+}
@@ -0,0 +1,331 @@
+// Package cache implements an on-disk, content-addressed cache of parcels and invoices for the
+// Bindle client. Parcels are keyed by their SHA256, so a parcel shared by several bindles is only
+// stored once, and invoices are keyed by their ID since they are immutable. Parcel data is checked
+// against its SHA when read back, the total size of cached parcels can be limited (evicting the
+// least recently used parcels first) and several processes can safely share the same cache
+// directory
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/deislabs/go-bindle/internal/fsutil"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+const (
+	invoiceDirectory = "invoices"
+	parcelDirectory  = "parcels"
+	evictLockName    = "evict"
+)
+
+// ErrCacheMiss is returned when the requested invoice or parcel isn't in the cache
+var ErrCacheMiss = errors.New("not found in cache")
+
+// ErrCorrupt is returned when data read from (or written to) the cache doesn't match its SHA256.
+// Corrupt cache entries are removed when detected
+var ErrCorrupt = errors.New("data does not match its SHA256")
+
+// ErrInvalidSHA is returned when a parcel SHA is not a SHA256 encoded as 64 lowercase hex
+// characters. Parcel SHAs are used as file names, so anything else is refused
+var ErrInvalidSHA = errors.New("invalid parcel SHA256")
+
+// Cache is an on-disk cache of parcels and invoices
+type Cache struct {
+	// Dir is the directory the cache is stored in
+	Dir string
+	// MaxSize is the maximum total size in bytes of the cached parcels. When it is exceeded, the
+	// least recently used parcels are evicted. Invoices are small and don't count towards the
+	// limit. If 0, the size is unlimited
+	MaxSize int64
+}
+
+// New returns a Cache stored in the given directory (which is created if it doesn't exist) with the
+// given maximum size. Pass 0 for an unlimited size
+func New(dir string, maxSize int64) (*Cache, error) {
+	for _, sub := range []string{invoiceDirectory, parcelDirectory} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("unable to create cache directory: %w", err)
+		}
+	}
+	return &Cache{Dir: dir, MaxSize: maxSize}, nil
+}
+
+// GetInvoice returns the cached invoice with the given ID
+func (c *Cache) GetInvoice(id string) (*types.Invoice, error) {
+	raw, err := ioutil.ReadFile(c.invoicePath(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("invoice %s: %w", id, ErrCacheMiss)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read cached invoice %s: %w", id, err)
+	}
+
+	var inv types.Invoice
+	if err := toml.Unmarshal(raw, &inv); err != nil {
+		// Drop unreadable entries so they get fetched again
+		os.Remove(c.invoicePath(id))
+		return nil, fmt.Errorf("invoice %s: %w", id, ErrCorrupt)
+	}
+	return &inv, nil
+}
+
+// PutInvoice adds the invoice to the cache, replacing any cached copy
+func (c *Cache) PutInvoice(inv *types.Invoice) error {
+	raw, err := inv.MarshalCanonical()
+	if err != nil {
+		return fmt.Errorf("unable to encode invoice: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(c.invoicePath(inv.Name()), raw, 0644); err != nil {
+		return fmt.Errorf("unable to cache invoice: %w", err)
+	}
+	return nil
+}
+
+// ListInvoices returns every cached invoice
+func (c *Cache) ListInvoices() ([]types.Invoice, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(c.Dir, invoiceDirectory))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list cached invoices: %w", err)
+	}
+
+	var invoices []types.Invoice
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".toml" {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(c.Dir, invoiceDirectory, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var inv types.Invoice
+		if err := toml.Unmarshal(raw, &inv); err != nil {
+			continue
+		}
+		invoices = append(invoices, inv)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Name() < invoices[j].Name() })
+	return invoices, nil
+}
+
+// HasParcel returns true if the parcel with the given SHA is cached
+func (c *Cache) HasParcel(sha string) bool {
+	path, err := c.parcelPath(sha)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// GetParcel returns a reader for the cached parcel with the given SHA. The data is hashed as it is
+// read and reading the end of the data returns an error wrapping ErrCorrupt if it doesn't match the
+// SHA. Reading a parcel marks it as recently used. The caller must close the reader
+func (c *Cache) GetParcel(sha string) (io.ReadCloser, error) {
+	path, err := c.parcelPath(sha)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("parcel %s: %w", sha, ErrCacheMiss)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open cached parcel %s: %w", sha, err)
+	}
+
+	// The modification time is used as the last access time for eviction. Failing to update it only
+	// makes the parcel more likely to be evicted, so ignore any errors
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return &verifyingReader{file: file, hash: sha256.New(), sha: sha, path: path}, nil
+}
+
+// PutParcel adds the parcel data to the cache, verifying it matches the given SHA
+func (c *Cache) PutParcel(sha string, data io.Reader) error {
+	w, err := c.NewParcelWriter(sha)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, data); err != nil {
+		w.Abort()
+		return fmt.Errorf("unable to cache parcel %s: %w", sha, err)
+	}
+	return w.Commit()
+}
+
+// NewParcelWriter returns a writer that adds a parcel to the cache once all of its data has been
+// written and `Commit` is called. This allows caching a parcel while it is streamed somewhere else
+func (c *Cache) NewParcelWriter(sha string) (*ParcelWriter, error) {
+	path, err := c.parcelPath(sha)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+sha+".part*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cache file: %w", err)
+	}
+	return &ParcelWriter{cache: c, file: tmp, hash: sha256.New(), sha: sha, path: path}, nil
+}
+
+// Size returns the total size in bytes of all cached parcels
+func (c *Cache) Size() (int64, error) {
+	entries, err := c.parcelEntries()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size()
+	}
+	return total, nil
+}
+
+// Evict removes the least recently used parcels until the cache is no larger than MaxSize. It is
+// called automatically whenever a parcel is added, so it only needs to be called after lowering
+// MaxSize
+func (c *Cache) Evict() error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+
+	unlock, err := fsutil.Lock(filepath.Join(c.Dir, evictLockName), fsutil.DefaultLockTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to lock cache: %w", err)
+	}
+	defer unlock()
+
+	entries, err := c.parcelEntries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+	for _, entry := range entries {
+		if total <= c.MaxSize {
+			break
+		}
+		// Readers that already opened the file can still finish reading it
+		if err := os.Remove(filepath.Join(c.Dir, parcelDirectory, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to evict parcel %s: %w", entry.Name(), err)
+		}
+		total -= entry.Size()
+	}
+	return nil
+}
+
+// parcelEntries returns the file info of every cached parcel, skipping partially written ones
+func (c *Cache) parcelEntries() ([]os.FileInfo, error) {
+	all, err := ioutil.ReadDir(filepath.Join(c.Dir, parcelDirectory))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list cached parcels: %w", err)
+	}
+	entries := make([]os.FileInfo, 0, len(all))
+	for _, entry := range all {
+		if entry.IsDir() || entry.Name()[0] == '.' {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (c *Cache) invoicePath(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(c.Dir, invoiceDirectory, hex.EncodeToString(sum[:])+".toml")
+}
+
+// parcelPath returns the path of the cached parcel, or an error wrapping ErrInvalidSHA if the SHA
+// is not valid
+func (c *Cache) parcelPath(sha string) (string, error) {
+	if !fsutil.ValidSHA256(sha) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSHA, sha)
+	}
+	return filepath.Join(c.Dir, parcelDirectory, sha), nil
+}
+
+// ParcelWriter writes a parcel into the cache. Nothing is visible in the cache until `Commit` is
+// called. Either `Commit` or `Abort` must be called to clean up
+type ParcelWriter struct {
+	cache *Cache
+	file  *os.File
+	hash  hash.Hash
+	sha   string
+	path  string
+	done  bool
+}
+
+// Write implements `io.Writer`
+func (w *ParcelWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// Commit verifies the written data against the SHA and, if it matches, moves it into the cache.
+// Returns an error wrapping ErrCorrupt if the data doesn't match
+func (w *ParcelWriter) Commit() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	defer os.Remove(w.file.Name())
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("unable to write cached parcel %s: %w", w.sha, err)
+	}
+	if hex.EncodeToString(w.hash.Sum(nil)) != w.sha {
+		return fmt.Errorf("parcel %s: %w", w.sha, ErrCorrupt)
+	}
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		return fmt.Errorf("unable to cache parcel %s: %w", w.sha, err)
+	}
+	return w.cache.Evict()
+}
+
+// Abort discards the written data
+func (w *ParcelWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+// verifyingReader hashes a cached parcel as it is read and checks it against the SHA at the end
+type verifyingReader struct {
+	file *os.File
+	hash hash.Hash
+	sha  string
+	path string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.sha {
+		os.Remove(r.path)
+		return n, fmt.Errorf("cached parcel %s: %w", r.sha, ErrCorrupt)
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/deislabs/go-bindle/cache"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// WithCache enables caching of invoices and parcels in the given local cache. Invoices and parcels
+// are served from the cache when available and added to it when fetched from the server. Since
+// invoices are immutable, a cached invoice is used without checking with the server, so a yank
+// that happened after it was cached is only seen by `GetInvoice` once the invoice is evicted.
+// The cache may be shared with clients that don't verify host signatures, so invoices served from
+// the cache are checked for a host signature (if enabled) just like invoices from the server
+func WithCache(c *cache.Cache) Option {
+	return func(client *Client) {
+		client.cache = c
+	}
+}
+
+// cachedInvoice returns the invoice from the cache if caching is enabled and it is cached. Yanked
+// invoices are only returned if yanked is true. An error is returned if the cached invoice fails
+// host verification
+func (c *Client) cachedInvoice(id string, yanked bool) (*types.Invoice, bool, error) {
+	if c.cache == nil {
+		return nil, false, nil
+	}
+	inv, err := c.cache.GetInvoice(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	if !yanked && inv.Yanked != nil && *inv.Yanked {
+		return nil, false, nil
+	}
+	if err := c.verifyHostSignature(inv); err != nil {
+		return nil, false, err
+	}
+	return inv, true, nil
+}
+
+// cacheInvoice adds the invoice to the cache if caching is enabled. Caching is best effort, so
+// errors are ignored
+func (c *Client) cacheInvoice(inv *types.Invoice) {
+	if c.cache != nil {
+		c.cache.PutInvoice(inv)
+	}
+}
+
+func (c *Client) readCachedParcel(sha string) ([]byte, error) {
+	cached, err := c.cache.GetParcel(sha)
+	if err != nil {
+		return nil, err
+	}
+	defer cached.Close()
+	return ioutil.ReadAll(cached)
+}
+
+// cachingReader adds a parcel to the cache while it is being read from the server. The parcel is
+// only committed to the cache once all of it has been read and it matches its SHA
+type cachingReader struct {
+	body   io.ReadCloser
+	writer *cache.ParcelWriter
+}
+
+func (c *Client) newCachingReader(sha string, body io.ReadCloser) io.ReadCloser {
+	writer, err := c.cache.NewParcelWriter(sha)
+	if err != nil {
+		// If the cache isn't writable, the parcel can still be read from the server
+		return body
+	}
+	return &cachingReader{body: body, writer: writer}
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if r.writer == nil {
+		return n, err
+	}
+
+	if _, writeErr := r.writer.Write(p[:n]); writeErr != nil {
+		r.writer.Abort()
+		r.writer = nil
+		return n, err
+	}
+	if err == io.EOF {
+		commitErr := r.writer.Commit()
+		r.writer = nil
+		if errors.Is(commitErr, cache.ErrCorrupt) {
+			return n, fmt.Errorf("Parcel data from the server does not match its SHA: %w", commitErr)
+		}
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	if r.writer != nil {
+		r.writer.Abort()
+	}
+	return r.body.Close()
+}
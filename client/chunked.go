@@ -0,0 +1,162 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/deislabs/go-bindle/types"
+)
+
+// DownloadOption configures optional behavior of `DownloadParcel`
+type DownloadOption func(*downloadOptions)
+
+type downloadOptions struct {
+	chunkSize   int64
+	concurrency int
+}
+
+// chunked returns true if a parcel of the given size should be downloaded in chunks
+func (o *downloadOptions) chunked(size uint64) bool {
+	return o.chunkSize > 0 && o.concurrency > 1 && size > uint64(o.chunkSize)
+}
+
+// WithParallelChunks downloads parcels larger than chunkSize as byte ranges of chunkSize, fetching
+// up to concurrency ranges at the same time. Each range is written in place to the download file
+// and the whole file is verified against the label once all ranges are done. When the client uses
+// TLS, the requests are multiplexed over a single HTTP/2 connection. If the server doesn't support
+// range requests, the parcel is downloaded as a single stream instead. Unlike single stream
+// downloads, an interrupted chunked download starts over
+func WithParallelChunks(chunkSize int64, concurrency int) DownloadOption {
+	return func(o *downloadOptions) {
+		o.chunkSize = chunkSize
+		o.concurrency = concurrency
+	}
+}
+
+type chunk struct {
+	offset int64
+	length int64
+}
+
+// downloadChunks downloads the parcel into the file in parallel chunks
+func (c *Client) downloadChunks(bindleID string, label types.Label, file *os.File, options downloadOptions, reporter *progressReporter) error {
+	size := int64(label.Size)
+	var chunks []chunk
+	for offset := int64(0); offset < size; offset += options.chunkSize {
+		length := options.chunkSize
+		if offset+length > size {
+			length = size - offset
+		}
+		chunks = append(chunks, chunk{offset: offset, length: length})
+	}
+
+	// The first chunk doubles as a check whether the server supports range requests
+	first, ranged, err := c.parcelRange(bindleID, label.SHA256, chunks[0].offset, chunks[0].length)
+	if err != nil {
+		return err
+	}
+	if !ranged {
+		defer first.Close()
+		if err := file.Truncate(0); err != nil {
+			return fmt.Errorf("Unable to write parcel data: %w", err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("Unable to write parcel data: %w", err)
+		}
+		if _, err := io.Copy(file, &ProgressReader{reader: first, reporter: reporter}); err != nil {
+			return fmt.Errorf("Unable to download parcel %s: %w", label.SHA256, err)
+		}
+		return nil
+	}
+
+	// Size the file up front so every chunk can be written at its offset
+	if err := file.Truncate(size); err != nil {
+		first.Close()
+		return fmt.Errorf("Unable to write parcel data: %w", err)
+	}
+
+	work := make(chan chunk)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	setErr := func(err error) {
+		if err == nil {
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return firstErr != nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		setErr(writeChunk(file, first, chunks[0], label.SHA256, reporter))
+	}()
+	for w := 0; w < options.concurrency-1; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ch := range work {
+				if failed() {
+					continue
+				}
+				body, ranged, err := c.parcelRange(bindleID, label.SHA256, ch.offset, ch.length)
+				if err != nil {
+					setErr(err)
+					continue
+				}
+				if !ranged {
+					body.Close()
+					setErr(fmt.Errorf("Unable to download parcel %s: %w", label.SHA256, ErrRangeNotSupported))
+					continue
+				}
+				setErr(writeChunk(file, body, ch, label.SHA256, reporter))
+			}
+		}()
+	}
+	for _, ch := range chunks[1:] {
+		work <- ch
+	}
+	close(work)
+	wg.Wait()
+
+	return firstErr
+}
+
+// writeChunk copies the body of a range response into the file at the chunk's offset and closes
+// the body
+func writeChunk(file *os.File, body io.ReadCloser, ch chunk, sha string, reporter *progressReporter) error {
+	defer body.Close()
+	data := &ProgressReader{reader: body, reporter: reporter}
+	n, err := io.Copy(&offsetWriter{file: file, offset: ch.offset}, io.LimitReader(data, ch.length))
+	if err != nil {
+		return fmt.Errorf("Unable to download bytes %d-%d of parcel %s: %w", ch.offset, ch.offset+ch.length-1, sha, err)
+	}
+	if n != ch.length {
+		return fmt.Errorf("Unable to download bytes %d-%d of parcel %s: got %d bytes instead of %d", ch.offset, ch.offset+ch.length-1, sha, n, ch.length)
+	}
+	return nil
+}
+
+// offsetWriter writes to a file sequentially starting at an offset, so several can write to
+// different parts of the same file at the same time
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -2,15 +2,21 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 
+	"github.com/deislabs/go-bindle/cache"
 	"github.com/deislabs/go-bindle/types"
 
 	"github.com/pelletier/go-toml"
@@ -20,21 +26,89 @@ import (
 const invoiceEndpoint = "_i"
 const queryEndpoint = "_q"
 const relationshipEndpoint = "_r"
+const bindleKeysEndpoint = "bindle-keys"
 const tomlMimeType = "application/toml"
+const jsonMimeType = "application/json"
+
+// ErrNotFound is matched (using `errors.Is`) by errors for requests the server responded to with a
+// 404 Not Found status, such as fetching an invoice that doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is matched (using `errors.Is`) by errors for requests the server responded to with a
+// 409 Conflict status, such as creating an invoice or parcel that already exists
+var ErrConflict = errors.New("conflict")
+
+// WireFormat is the serialization format used for request and response bodies when talking to a
+// Bindle server
+type WireFormat int
+
+const (
+	// FormatTOML is the default wire format
+	FormatTOML WireFormat = iota
+	// FormatJSON requests JSON responses from the server and sends JSON request bodies
+	FormatJSON
+)
+
+func (f WireFormat) mimeType() string {
+	if f == FormatJSON {
+		return jsonMimeType
+	}
+	return tomlMimeType
+}
 
 // Client is the struct that contains all necessary information for communicating with a Bindle
 // Server
 type Client struct {
 	httpClient http.Client
 	baseURL    *url.URL
+	wireFormat WireFormat
+	// hostVerifier is only set if host signature verification was requested. It is a pointer so
+	// the Client can still be safely copied
+	hostVerifier *hostVerifier
+	cache        *cache.Cache
+	offlineMode  OfflineMode
+	progress     ProgressFunc
+}
+
+// Option configures optional behavior of a Client. Options are passed to `New`
+type Option func(*Client)
+
+// WithHostVerification enables verification of the `host` signature on every invoice returned by
+// `GetInvoice`, `GetYankedInvoice` and `QueryInvoices`. Invoices with a missing or invalid host
+// signature are refused with an error. If hostKeys is nil, the host keys are fetched from the
+// server's bindle-keys endpoint the first time they are needed and reused after that
+func WithHostVerification(hostKeys *types.Keyring) Option {
+	return func(c *Client) {
+		c.hostVerifier = &hostVerifier{}
+		if hostKeys != nil {
+			c.hostVerifier.keys = hostKeys.Key
+			c.hostVerifier.loaded = true
+		}
+	}
+}
+
+// WithWireFormat sets the format used for request bodies and requested (with the `Accept` header)
+// for responses. Responses are always decoded based on the `Content-Type` the server sends, so
+// servers that don't support the requested format still work. Defaults to `FormatTOML`
+func WithWireFormat(format WireFormat) Option {
+	return func(c *Client) {
+		c.wireFormat = format
+	}
+}
+
+type hostVerifier struct {
+	lock   sync.Mutex
+	keys   []types.SignatureKey
+	loaded bool
 }
 
 // New returns a new Client configured to use the given baseURL. This URL should be the entire base
 // part of your Bindle server. So if your Bindle server is namespaced (with something like v1), then
 // the baseURL should contain that part of the URL (e.g. https://bindle.example.com/v1 instead of
 // https://bindle.example.com). The tlsConfig parameter is optional and can be used if you have any
-// specific TLS configuration options such as internally signed certificates
-func New(baseURL string, tlsConfig *tls.Config) (*Client, error) {
+// specific TLS configuration options such as internally signed certificates. Any additional
+// behavior (such as host signature verification) can be enabled by passing `Option`s
+func New(baseURL string, tlsConfig *tls.Config, opts ...Option) (*Client, error) {
 	httpClient := http.Client{}
 
 	if tlsConfig != nil {
@@ -53,16 +127,37 @@ func New(baseURL string, tlsConfig *tls.Config) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Invalid base URL: %s", err)
 	}
-	return &Client{
+	c := &Client{
 		httpClient: httpClient,
 		baseURL:    u,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.offlineMode != OfflineDisabled && c.cache == nil {
+		return nil, fmt.Errorf("Offline mode requires a cache to be configured with WithCache")
+	}
+	return c, nil
 }
 
 // RawRequests performs an HTTP request using the underlying HTTP client and base URL. The given
 // path is appended to the URL and the data body is optional. If a body is specified, the
 // contentType can be specified as well, otherwise contentType will be ignored
 func (c *Client) RawRequest(path string, method string, data io.ReadCloser, contentType string) (*http.Response, error) {
+	return c.rawRequest(path, method, data, http.Header{
+		"Content-Type": []string{contentType},
+	})
+}
+
+func (c *Client) rawRequest(path string, method string, data io.ReadCloser, header http.Header) (*http.Response, error) {
+	return c.rawRequestContext(context.Background(), path, method, data, header)
+}
+
+func (c *Client) rawRequestContext(ctx context.Context, path string, method string, data io.ReadCloser, header http.Header) (*http.Response, error) {
+	if c.offlineMode == OfflineStrict {
+		return nil, ErrOffline
+	}
+
 	u := *c.baseURL
 	// Parse as a URL so we can get the separate components
 	parsedPath, err := url.Parse(path)
@@ -76,15 +171,22 @@ func (c *Client) RawRequest(path string, method string, data io.ReadCloser, cont
 		Method: method,
 		URL:    &u,
 		Body:   data,
-		Header: http.Header{
-			"Content-Type": []string{contentType},
-		},
+		Header: header,
 	}
-	return c.httpClient.Do(req)
+	return c.httpClient.Do(req.WithContext(ctx))
 }
 
+// requestAndUnmarshal performs an API request, asking for a response in the configured wire format,
+// and decodes the response into v
 func (c *Client) requestAndUnmarshal(path string, method string, data io.ReadCloser, contentType string, v interface{}) error {
-	resp, err := c.RawRequest(path, method, data, contentType)
+	return c.requestAndUnmarshalContext(context.Background(), path, method, data, contentType, v)
+}
+
+func (c *Client) requestAndUnmarshalContext(ctx context.Context, path string, method string, data io.ReadCloser, contentType string, v interface{}) error {
+	resp, err := c.rawRequestContext(ctx, path, method, data, http.Header{
+		"Content-Type": []string{contentType},
+		"Accept":       []string{c.wireFormat.mimeType()},
+	})
 	if err != nil {
 		return err
 	}
@@ -97,33 +199,59 @@ func (c *Client) requestAndUnmarshal(path string, method string, data io.ReadClo
 // GetInvoice returns an `Invoice` with the given ID. This will return an error if the invoice is
 // yanked
 func (c *Client) GetInvoice(id string) (*types.Invoice, error) {
+	if inv, ok, err := c.cachedInvoice(id, false); err != nil {
+		return nil, err
+	} else if ok {
+		return inv, nil
+	}
+	if c.offlineMode == OfflineStrict {
+		return nil, cacheMiss("invoice", id)
+	}
+
 	var inv types.Invoice
 	if err := c.requestAndUnmarshal(fmt.Sprintf("/%s/%s", invoiceEndpoint, id), http.MethodGet, nil, "", &inv); err != nil {
+		return nil, c.offlineError(err)
+	}
+	if err := c.verifyHostSignature(&inv); err != nil {
 		return nil, err
 	}
+	c.cacheInvoice(&inv)
 	return &inv, nil
 }
 
 // GetYankedInvoice is the same as `GetInvoice`, but allows you to return an invoice that has been
 // yanked
 func (c *Client) GetYankedInvoice(id string) (*types.Invoice, error) {
+	if inv, ok, err := c.cachedInvoice(id, true); err != nil {
+		return nil, err
+	} else if ok {
+		return inv, nil
+	}
+	if c.offlineMode == OfflineStrict {
+		return nil, cacheMiss("invoice", id)
+	}
+
 	var inv types.Invoice
 	if err := c.requestAndUnmarshal(fmt.Sprintf("/%s/%s?yanked=true", invoiceEndpoint, id), http.MethodGet, nil, "", &inv); err != nil {
+		return nil, c.offlineError(err)
+	}
+	if err := c.verifyHostSignature(&inv); err != nil {
 		return nil, err
 	}
+	c.cacheInvoice(&inv)
 	return &inv, nil
 }
 
 // CreateInvoice from the given `Invoice` object. Returns a response containing the newly created
 // invoice and a list of any missing parcels that need to be uploaded
 func (c *Client) CreateInvoice(inv types.Invoice) (*types.InvoiceCreateResponse, error) {
-	body, err := encodeToBuffer(&inv)
+	body, err := c.encodeInvoice(&inv)
 	if err != nil {
 		return nil, err
 	}
 
 	var invResp types.InvoiceCreateResponse
-	if err := c.requestAndUnmarshal(fmt.Sprintf("/%s", invoiceEndpoint), http.MethodPost, body, tomlMimeType, &invResp); err != nil {
+	if err := c.requestAndUnmarshal(fmt.Sprintf("/%s", invoiceEndpoint), http.MethodPost, body, c.wireFormat.mimeType(), &invResp); err != nil {
 		return nil, err
 	}
 
@@ -151,10 +279,22 @@ func (c *Client) CreateInvoiceFromFile(path string) (*types.InvoiceCreateRespons
 // on the server, particularly in their use of `strict` mode. Returns a `Matches` object containing
 // information about the query, pagination data, and the list of responses
 func (c *Client) QueryInvoices(opts types.QueryOptions) (*types.Matches, error) {
+	if c.offlineMode == OfflineStrict {
+		return c.queryCache(opts)
+	}
+
 	var matches types.Matches
 	if err := c.requestAndUnmarshal(fmt.Sprintf("/%s%s", queryEndpoint, opts.QueryString()), http.MethodGet, nil, tomlMimeType, &matches); err != nil {
+		if c.offlineMode == OfflineFallback && isServerFailure(err) {
+			return c.queryCache(opts)
+		}
 		return nil, err
 	}
+	for i := range matches.Invoices {
+		if err := c.verifyHostSignature(&matches.Invoices[i]); err != nil {
+			return nil, err
+		}
+	}
 	return &matches, nil
 }
 
@@ -168,8 +308,24 @@ func (c *Client) YankInvoice(id string) error {
 	return nil
 }
 
-// Performs the request against the parcel endpoint and handles any http errors, returning the HTTP body
-func (c *Client) doParcelRequest(bindleID string, sha string, method string, body io.ReadCloser) (io.ReadCloser, error) {
+// ApproveInvoice fetches the invoice with the given ID, verifies its creator signatures using the
+// given signature keys (generally from your keyring) and adds an approver signature for the given
+// approver. The approved invoice is returned ready to be submitted (e.g. with `CreateInvoice`) to
+// a Bindle server. See `Invoice.Approve` for more details
+func (c *Client) ApproveInvoice(id string, approver string, sigKey *types.SignatureKey, privKey []byte, sigKeys []types.SignatureKey) (*types.Invoice, error) {
+	inv, err := c.GetInvoice(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := inv.Approve(approver, sigKey, privKey, sigKeys); err != nil {
+		return nil, fmt.Errorf("Unable to approve invoice %s: %w", id, err)
+	}
+	return inv, nil
+}
+
+// Performs the request against the parcel endpoint and handles any http errors, returning the HTTP response
+func (c *Client) doParcelRequest(bindleID string, sha string, method string, body io.ReadCloser) (*http.Response, error) {
 	resp, err := c.RawRequest(fmt.Sprintf("/%s/%s@%s", invoiceEndpoint, bindleID, sha), method, body, "")
 	if err != nil {
 		return nil, err
@@ -178,14 +334,33 @@ func (c *Client) doParcelRequest(bindleID string, sha string, method string, bod
 	if resp.StatusCode > 299 || resp.StatusCode < 200 {
 		return nil, unmarshalResponse(resp, nil)
 	}
-	return resp.Body, nil
+	return resp, nil
+}
+
+// uploadParcel uploads the parcel data of the given size (or -1 if unknown), reporting progress if
+// enabled
+func (c *Client) uploadParcel(bindleID string, sha string, data io.ReadCloser, size int64) error {
+	body := NewProgressReader(data, Progress{Direction: Upload, BindleID: bindleID, SHA: sha, Total: size}, c.progress)
+	resp, err := c.doParcelRequest(bindleID, sha, http.MethodPost, body)
+	if err == nil {
+		resp.Body.Close()
+	}
+	body.Finish(err)
+	return err
 }
 
 // GetParcel returns the parcel identified by the Bindle ID and parcel SHA. This loads the data into
 // memory as a byte array and is not recommended for use with larger parcels. For larger parcels (or
 // when writing directly to another source), use the `GetParcelReader` function instead
 func (c *Client) GetParcel(bindleID string, sha string) ([]byte, error) {
-	body, err := c.doParcelRequest(bindleID, sha, http.MethodGet, nil)
+	if c.cache != nil {
+		// A corrupt cache entry is removed when detected, so fall back to the server in that case
+		if data, err := c.readCachedParcel(sha); err == nil {
+			return data, nil
+		}
+	}
+
+	body, err := c.GetParcelReader(bindleID, sha)
 	if err != nil {
 		return nil, err
 	}
@@ -195,9 +370,35 @@ func (c *Client) GetParcel(bindleID string, sha string) ([]byte, error) {
 }
 
 // GetParcelReader is similar to `GetParcel` but returns the parcel as a reader (for streaming
-// purposes). This will be more efficient for larger files
+// purposes). This will be more efficient for larger files. If caching is enabled, cached parcels
+// are checked against their SHA while being read
 func (c *Client) GetParcelReader(bindleID string, sha string) (io.ReadCloser, error) {
-	return c.doParcelRequest(bindleID, sha, http.MethodGet, nil)
+	if c.cache != nil {
+		if cached, err := c.cache.GetParcel(sha); err == nil {
+			return cached, nil
+		}
+	}
+
+	if c.offlineMode == OfflineStrict {
+		return nil, cacheMiss("parcel", sha)
+	}
+
+	resp, err := c.doParcelRequest(bindleID, sha, http.MethodGet, nil)
+	if err != nil {
+		return nil, c.offlineError(err)
+	}
+	body := resp.Body
+	if c.progress != nil {
+		body = &ProgressReader{
+			reader:      body,
+			reporter:    newProgressReporter(c.progress, Progress{Direction: Download, BindleID: bindleID, SHA: sha, Total: resp.ContentLength}),
+			finishOnEOF: true,
+		}
+	}
+	if c.cache != nil {
+		return c.newCachingReader(sha, body), nil
+	}
+	return body, nil
 }
 
 // CreateParcel uploads a parcel for the given `bindleID`. The `sha` value must match the SHA256 sum
@@ -209,8 +410,7 @@ func (c *Client) GetParcelReader(bindleID string, sha string) (io.ReadCloser, er
 // exist as indicated by the server (either in the `InvoiceCreateResponse` or by using the
 // `GetMissingParcels` function)
 func (c *Client) CreateParcel(bindleID string, sha string, data []byte) error {
-	_, err := c.doParcelRequest(bindleID, sha, http.MethodPost, ioutil.NopCloser(bytes.NewReader(data)))
-	return err
+	return c.uploadParcel(bindleID, sha, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)))
 }
 
 // CreateParcelFromFile is the same as `CreateParcel` but takes a path to a file to upload for a
@@ -221,17 +421,19 @@ func (c *Client) CreateParcelFromFile(bindleID string, sha string, path string)
 		return err
 	}
 	defer file.Close()
-	_, err = c.doParcelRequest(bindleID, sha, http.MethodPost, file)
-	return err
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return c.uploadParcel(bindleID, sha, file, info.Size())
 }
 
 // CreateParcelFromReader is the same as `CreateParcel` but takes anything that is a `ReadCloser` to
 // use for the parcel. This function will stream the data from the reader to the server and then
 // close the reader
 func (c *Client) CreateParcelFromReader(bindleID string, sha string, data io.ReadCloser) error {
-	_, err := c.doParcelRequest(bindleID, sha, http.MethodPost, data)
 	defer data.Close()
-	return err
+	return c.uploadParcel(bindleID, sha, data, -1)
 }
 
 // GetMissingParcels checks with the server if there are any missing parcels for the given Bindle
@@ -244,33 +446,131 @@ func (c *Client) GetMissingParcels(id string) (*types.MissingParcelsResponse, er
 	return &missing, nil
 }
 
+// GetHostKeys fetches the public keys the Bindle server uses for signing, as a `Keyring`. If any
+// roles are given, only keys for those roles are returned, otherwise the server returns its
+// `host` keys. The request is canceled if ctx is done before the server responds. These keys can
+// be added to a local keyring with `keyring.TrustHostKeys`
+func (c *Client) GetHostKeys(ctx context.Context, roles ...string) (*types.Keyring, error) {
+	path := fmt.Sprintf("/%s", bindleKeysEndpoint)
+	if len(roles) > 0 {
+		path = fmt.Sprintf("%s?roles=%s", path, url.QueryEscape(strings.Join(roles, ",")))
+	}
+
+	var keyring types.Keyring
+	if err := c.requestAndUnmarshalContext(ctx, path, http.MethodGet, nil, "", &keyring); err != nil {
+		return nil, err
+	}
+	return &keyring, nil
+}
+
+// verifyHostSignature checks the host signature on the given invoice if host verification is
+// enabled, fetching the host keys from the server if they haven't been loaded yet
+func (c *Client) verifyHostSignature(inv *types.Invoice) error {
+	if c.hostVerifier == nil {
+		return nil
+	}
+
+	keys, err := c.loadHostKeys()
+	if err != nil {
+		return fmt.Errorf("Unable to load host keys: %w", err)
+	}
+
+	if err := inv.VerifyHostSignature(keys); err != nil {
+		return fmt.Errorf("Host signature verification failed for invoice %s: %w", inv.Name(), err)
+	}
+	return nil
+}
+
+func (c *Client) loadHostKeys() ([]types.SignatureKey, error) {
+	c.hostVerifier.lock.Lock()
+	defer c.hostVerifier.lock.Unlock()
+
+	if c.hostVerifier.loaded {
+		return c.hostVerifier.keys, nil
+	}
+
+	keyring, err := c.GetHostKeys(context.Background(), types.RoleHost)
+	if err != nil {
+		return nil, err
+	}
+
+	c.hostVerifier.keys = keyring.Key
+	c.hostVerifier.loaded = true
+	return c.hostVerifier.keys, nil
+}
+
+// statusError is returned when the server responds with a non-2xx status code
+type statusError struct {
+	code int
+	// message is the error message from the response body, if there was one
+	message string
+}
+
+// Is matches the status code against the sentinel errors for specific statuses
+func (e *statusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.code == http.StatusNotFound
+	case ErrConflict:
+		return e.code == http.StatusConflict
+	}
+	return false
+}
+
+func (e *statusError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("Error making request (HTTP status code %v)", e.code)
+	}
+	return fmt.Sprintf("Error making request (HTTP status code %v): %s", e.code, e.message)
+}
+
 func unmarshalResponse(resp *http.Response, v interface{}) error {
 	defer resp.Body.Close()
+	contentType := resp.Header.Get("Content-Type")
 	if resp.StatusCode > 299 || resp.StatusCode < 200 {
 		var errorInfo types.ErrorResponse
-		var err error
+		err := &statusError{code: resp.StatusCode}
 		// Try to get an error message. Not all errors will have them, so do not error out if it
 		// fails
-		if toml.NewDecoder(resp.Body).Strict(true).Decode(&errorInfo) == nil {
-			err = fmt.Errorf("Error making request (HTTP status code %v): %s", resp.StatusCode, errorInfo.Error)
-		} else {
-			err = fmt.Errorf("Error making request (HTTP status code %v)", resp.StatusCode)
+		if decodeBody(contentType, resp.Body, &errorInfo) == nil {
+			err.message = errorInfo.Error
 		}
 		return err
 	}
 	// Sometimes we want to try and unmarshal the error above, but not handle the body
 	if v != nil {
-		if err := toml.NewDecoder(resp.Body).Strict(true).Decode(v); err != nil {
+		if err := decodeBody(contentType, resp.Body, v); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func encodeToBuffer(v interface{}) (io.ReadCloser, error) {
-	var buf bytes.Buffer
-	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+// decodeBody decodes the body using the decoder for the given content type. Anything that isn't
+// JSON is assumed to be TOML, which is the default format for Bindle
+func decodeBody(contentType string, body io.Reader, v interface{}) error {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == jsonMimeType {
+		decoder := json.NewDecoder(body)
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(v)
+	}
+
+	return toml.NewDecoder(body).Strict(true).Decode(v)
+}
+
+// encodeInvoice encodes the invoice in the configured wire format. TOML invoices are always encoded
+// in their canonical form
+func (c *Client) encodeInvoice(inv *types.Invoice) (io.ReadCloser, error) {
+	var data []byte
+	var err error
+	if c.wireFormat == FormatJSON {
+		data, err = json.Marshal(inv)
+	} else {
+		data, err = inv.MarshalCanonical()
+	}
+	if err != nil {
 		return nil, err
 	}
-	return ioutil.NopCloser(&buf), nil
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
 }
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/deislabs/go-bindle/types"
+)
+
+// partialExtension is added to the destination path of a download while it is in progress
+const partialExtension = ".partial"
+
+// ErrRangeNotSupported is returned by `GetParcelRange` when the server doesn't support range
+// requests and responded with the whole parcel instead
+var ErrRangeNotSupported = errors.New("server does not support range requests")
+
+// ErrParcelMismatch is returned when downloaded parcel data doesn't match the SHA256 or size from
+// the parcel's label
+var ErrParcelMismatch = errors.New("downloaded parcel does not match its label")
+
+// GetParcelRange returns a reader for part of the parcel identified by the Bindle ID and parcel SHA,
+// starting at the given offset. If length is 0, the rest of the parcel is read, otherwise at most
+// length bytes. Returns ErrRangeNotSupported if the server doesn't support range requests. Ranges
+// are never served from the cache, so in strict offline mode this returns an error wrapping
+// `cache.ErrCacheMiss`
+func (c *Client) GetParcelRange(bindleID string, sha string, offset int64, length int64) (io.ReadCloser, error) {
+	body, ranged, err := c.parcelRange(bindleID, sha, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if !ranged {
+		body.Close()
+		return nil, ErrRangeNotSupported
+	}
+	return body, nil
+}
+
+// parcelRange requests part of a parcel. Servers that don't support range requests send the whole
+// parcel, in which case ranged is false and the body contains the whole parcel
+func (c *Client) parcelRange(bindleID string, sha string, offset int64, length int64) (body io.ReadCloser, ranged bool, err error) {
+	if offset < 0 || length < 0 {
+		return nil, false, fmt.Errorf("Invalid range: offset %d and length %d must not be negative", offset, length)
+	}
+	if c.offlineMode == OfflineStrict {
+		return nil, false, cacheMiss("parcel", sha)
+	}
+	rangeHeader := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	resp, err := c.rawRequest(fmt.Sprintf("/%s/%s@%s", invoiceEndpoint, bindleID, sha), http.MethodGet, nil, http.Header{
+		"Range": []string{rangeHeader},
+	})
+	if err != nil {
+		return nil, false, c.offlineError(err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			resp.Body.Close()
+			return nil, false, fmt.Errorf("Server responded with an unexpected range %q", resp.Header.Get("Content-Range"))
+		}
+		return resp.Body, true, nil
+	case http.StatusOK:
+		return resp.Body, false, nil
+	default:
+		return nil, false, c.offlineError(unmarshalResponse(resp, nil))
+	}
+}
+
+// DownloadParcel downloads the parcel with the given label from the given bindle to path. The data
+// is first written to `<path>.partial`, so if the download is interrupted, calling DownloadParcel
+// again resumes it from where it stopped using a range request (or starts over if the server
+// doesn't support them). Once complete, the data is verified against the SHA256 and size from the
+// label and moved to path. Returns an error wrapping ErrParcelMismatch if verification fails, in
+// which case the partial file is removed. Large parcels can be downloaded in parallel chunks with
+// `WithParallelChunks`. In strict offline mode, parcels that aren't cached fail with an error
+// wrapping `cache.ErrCacheMiss`
+func (c *Client) DownloadParcel(bindleID string, label types.Label, path string, opts ...DownloadOption) (err error) {
+	options := downloadOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	reporter := newProgressReporter(c.progress, Progress{Direction: Download, BindleID: bindleID, SHA: label.SHA256, Total: int64(label.Size)})
+	defer func() { reporter.finish(err) }()
+
+	cached := c.cache != nil && c.cache.HasParcel(label.SHA256)
+	if c.offlineMode == OfflineStrict && !cached {
+		return cacheMiss("parcel", label.SHA256)
+	}
+
+	partialPath := path + partialExtension
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to open partial download file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Unable to open partial download file: %w", err)
+	}
+	offset := info.Size()
+	if uint64(offset) > label.Size {
+		// This can't be the start of the parcel, so start over
+		offset = 0
+	}
+
+	if options.chunked(label.Size) && !cached {
+		if err := c.downloadChunks(bindleID, label, file, options, reporter); err != nil {
+			return err
+		}
+	} else if uint64(offset) < label.Size {
+		if err := c.downloadFrom(bindleID, label.SHA256, file, offset, reporter); err != nil {
+			return err
+		}
+	} else {
+		// An earlier call already downloaded everything
+		reporter.add(offset)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("Unable to write parcel data: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Unable to write parcel data: %w", err)
+	}
+	if err := verifyFile(partialPath, label); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	if err := os.Rename(partialPath, path); err != nil {
+		return fmt.Errorf("Unable to move downloaded parcel into place: %w", err)
+	}
+	return nil
+}
+
+// downloadFrom writes the parcel to the file starting at offset. Cached parcels are copied from the
+// cache instead
+func (c *Client) downloadFrom(bindleID string, sha string, file *os.File, offset int64, reporter *progressReporter) error {
+	var body io.ReadCloser
+	var ranged bool
+	if c.cache != nil {
+		if cached, err := c.cache.GetParcel(sha); err == nil {
+			body = cached
+		}
+	}
+	if body == nil {
+		var err error
+		if body, ranged, err = c.parcelRange(bindleID, sha, offset, 0); err != nil {
+			return err
+		}
+	}
+	defer body.Close()
+
+	if !ranged {
+		offset = 0
+	}
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("Unable to write parcel data: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("Unable to write parcel data: %w", err)
+	}
+	// Count the data downloaded before as transferred
+	reporter.add(offset)
+	if _, err := io.Copy(file, &ProgressReader{reader: body, reporter: reporter}); err != nil {
+		return fmt.Errorf("Unable to download parcel %s: %w", sha, err)
+	}
+	return nil
+}
+
+// verifyFile checks the SHA256 and size of the file at path against the label
+func verifyFile(path string, label types.Label) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("Unable to read downloaded parcel: %w", err)
+	}
+	if uint64(size) != label.Size {
+		return fmt.Errorf("Parcel %s is %d bytes instead of %d: %w", label.SHA256, size, label.Size, ErrParcelMismatch)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != label.SHA256 {
+		return fmt.Errorf("Parcel %s has a different SHA256: %w", label.SHA256, ErrParcelMismatch)
+	}
+	return nil
+}
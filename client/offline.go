@@ -0,0 +1,89 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/deislabs/go-bindle/cache"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// OfflineMode controls whether a client serves requests from its local cache instead of the server
+type OfflineMode int
+
+const (
+	// OfflineDisabled always uses the server for anything that isn't cached. This is the default
+	OfflineDisabled OfflineMode = iota
+	// OfflineFallback uses the server when it is reachable and falls back to the cache when the
+	// server can't be reached or fails with a 5xx status code
+	OfflineFallback
+	// OfflineStrict never contacts the server. Reads are only served from the cache and writes fail
+	// with ErrOffline
+	OfflineStrict
+)
+
+// ErrOffline is returned by any request that needs the server (such as creating invoices or
+// parcels) when the client is in strict offline mode
+var ErrOffline = errors.New("client is in strict offline mode")
+
+// ErrServerUnavailable is returned in fallback offline mode when the server couldn't be reached and
+// the requested data isn't in the cache either. In strict offline mode, data missing from the cache
+// is reported with an error wrapping `cache.ErrCacheMiss` instead
+var ErrServerUnavailable = errors.New("server is unavailable and the data is not cached")
+
+// WithOfflineMode serves `GetInvoice`, `GetYankedInvoice`, `GetParcel`, `GetParcelReader`,
+// `DownloadParcel` and `QueryInvoices` from the local cache when the server is unreachable
+// (`OfflineFallback`) or always (`OfflineStrict`). Queries answered from the cache only search
+// cached invoices, which are checked for a host signature (if enabled) like any other invoice. A
+// cache must be configured with `WithCache`
+func WithOfflineMode(mode OfflineMode) Option {
+	return func(c *Client) {
+		c.offlineMode = mode
+	}
+}
+
+// isServerFailure returns true if the error means the server couldn't serve the request at all, as
+// opposed to rejecting it (e.g. because the invoice doesn't exist). Only transport errors, such as
+// a refused connection or a timeout, and 5xx statuses count. Anything else, like a response that
+// can't be decoded or fails host verification, must not be papered over with cached data
+func isServerFailure(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+// offlineError converts an error from a request to the server into the error returned to the
+// caller, marking server failures as such when in fallback mode
+func (c *Client) offlineError(err error) error {
+	if c.offlineMode == OfflineFallback && isServerFailure(err) {
+		return fmt.Errorf("%w: %s", ErrServerUnavailable, err)
+	}
+	return err
+}
+
+// cacheMiss returns the error for data that isn't cached in strict offline mode
+func cacheMiss(kind string, id string) error {
+	return fmt.Errorf("Unable to get %s %s in offline mode: %w", kind, id, cache.ErrCacheMiss)
+}
+
+// queryCache answers a query using the invoices in the cache, verifying their host signatures like
+// `QueryInvoices` does for invoices from the server
+func (c *Client) queryCache(opts types.QueryOptions) (*types.Matches, error) {
+	invoices, err := c.cache.ListInvoices()
+	if err != nil {
+		return nil, err
+	}
+	matches := opts.Search(invoices)
+	for i := range matches.Invoices {
+		if err := c.verifyHostSignature(&matches.Invoices[i]); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
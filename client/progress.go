@@ -0,0 +1,241 @@
+package client
+
+import (
+	"io"
+	"sync"
+
+	"github.com/deislabs/go-bindle/types"
+)
+
+// Direction is the direction of a parcel transfer
+type Direction int
+
+const (
+	// Download is a transfer from the server
+	Download Direction = iota
+	// Upload is a transfer to the server
+	Upload
+)
+
+func (d Direction) String() string {
+	if d == Upload {
+		return "upload"
+	}
+	return "download"
+}
+
+// Progress describes how far along the transfer of a single parcel is
+type Progress struct {
+	Direction Direction
+	BindleID  string
+	// SHA is the SHA256 of the parcel being transferred
+	SHA string
+	// Transferred is the number of bytes transferred so far. For resumed downloads, this includes
+	// the bytes downloaded before
+	Transferred int64
+	// Total is the size of the parcel (from `Label.Size` where the label is known, otherwise from
+	// the response or file size), or -1 if it is unknown
+	Total int64
+	// Done is true for the last report of a transfer, whether it succeeded or not
+	Done bool
+	// Err is set on the last report if the transfer failed
+	Err error
+}
+
+// ProgressFunc is called with the progress of parcel transfers. It is called from the goroutine
+// doing the transfer, so it must be safe for concurrent use and should return quickly
+type ProgressFunc func(p Progress)
+
+// WithProgress reports the progress of every parcel upload and download to fn. This includes
+// `CreateParcel`, `CreateParcelFromFile`, `CreateParcelFromReader`, `GetParcel`, `GetParcelReader`
+// and `DownloadParcel`. To report the combined progress of several transfers, pass the `Observe`
+// method of a `ProgressTracker`
+func WithProgress(fn ProgressFunc) Option {
+	return func(c *Client) {
+		c.progress = fn
+	}
+}
+
+// progressReporter accumulates the progress of a single transfer and reports it
+type progressReporter struct {
+	lock     sync.Mutex
+	fn       ProgressFunc
+	progress Progress
+}
+
+// newProgressReporter returns a reporter for the transfer, or nil if fn is nil. All methods of a
+// nil reporter are no-ops
+func newProgressReporter(fn ProgressFunc, p Progress) *progressReporter {
+	if fn == nil {
+		return nil
+	}
+	return &progressReporter{fn: fn, progress: p}
+}
+
+func (r *progressReporter) add(n int64) {
+	if r == nil || n == 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.progress.Done {
+		return
+	}
+	r.progress.Transferred += n
+	r.fn(r.progress)
+}
+
+// finish sends the final report. Only the first call has any effect
+func (r *progressReporter) finish(err error) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.progress.Done {
+		return
+	}
+	r.progress.Done = true
+	r.progress.Err = err
+	r.fn(r.progress)
+}
+
+// ProgressReader reports the progress of a transfer as data is read through it. It is used by the
+// client for its own transfers and can be used to report progress from bulk operations built on top
+// of the client, such as copying parcels between servers
+type ProgressReader struct {
+	reader   io.ReadCloser
+	reporter *progressReporter
+	// finishOnEOF marks the transfer as done once all data is read or the reader is closed.
+	// Uploads are only done once the server responded, so they are finished explicitly instead
+	finishOnEOF bool
+}
+
+// NewProgressReader wraps reader so fn is called as data is read from it, starting from the given
+// progress. Call `Finish` once the transfer is complete to send the final report
+func NewProgressReader(reader io.ReadCloser, p Progress, fn ProgressFunc) *ProgressReader {
+	return &ProgressReader{reader: reader, reporter: newProgressReporter(fn, p)}
+}
+
+// Read implements `io.Reader`. Read errors other than `io.EOF` finish the transfer with that error
+func (r *ProgressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.reporter.add(int64(n))
+	if err == io.EOF && r.finishOnEOF {
+		r.reporter.finish(nil)
+	} else if err != nil && err != io.EOF {
+		r.reporter.finish(err)
+	}
+	return n, err
+}
+
+// Close closes the underlying reader
+func (r *ProgressReader) Close() error {
+	if r.finishOnEOF {
+		r.reporter.finish(nil)
+	}
+	return r.reader.Close()
+}
+
+// Finish sends the final report for the transfer, with the error it failed with (if any). Only the
+// first final report is sent
+func (r *ProgressReader) Finish(err error) {
+	r.reporter.finish(err)
+}
+
+// AggregateProgress is the combined progress of several parcel transfers
+type AggregateProgress struct {
+	// Transferred is the number of bytes transferred across all parcels
+	Transferred int64
+	// Total is the combined size of all parcels that are expected or have started transferring.
+	// Parcels of unknown size don't count towards it
+	Total int64
+	// Parcels is the number of parcels that are expected or have started transferring
+	Parcels int
+	// Completed is the number of parcels that are done transferring, whether they succeeded or not
+	Completed int
+	// Failed is the number of parcels that failed to transfer
+	Failed int
+}
+
+// ProgressTracker combines the progress of many parcel transfers, such as those of a bulk push or
+// pull. Pass its `Observe` method wherever a `ProgressFunc` is accepted. It is safe for concurrent
+// use
+type ProgressTracker struct {
+	lock    sync.Mutex
+	fn      func(parcel Progress, total AggregateProgress)
+	parcels map[progressKey]Progress
+	total   AggregateProgress
+}
+
+type progressKey struct {
+	direction Direction
+	sha       string
+}
+
+// NewProgressTracker returns a ProgressTracker calling fn with the progress of the parcel that
+// changed and the combined progress of all parcels
+func NewProgressTracker(fn func(parcel Progress, total AggregateProgress)) *ProgressTracker {
+	return &ProgressTracker{fn: fn, parcels: map[progressKey]Progress{}}
+}
+
+// Expect adds parcels that will be transferred in the given direction to the totals up front, so
+// the combined progress is accurate from the start instead of growing as transfers begin
+func (t *ProgressTracker) Expect(direction Direction, labels ...types.Label) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, label := range labels {
+		key := progressKey{direction: direction, sha: label.SHA256}
+		if _, exists := t.parcels[key]; exists {
+			continue
+		}
+		t.parcels[key] = Progress{Direction: direction, SHA: label.SHA256, Total: int64(label.Size)}
+		t.total.Parcels++
+		t.total.Total += int64(label.Size)
+	}
+}
+
+// Observe records the progress of a parcel transfer and reports it along with the combined
+// progress. It can be used as a `ProgressFunc`
+func (t *ProgressTracker) Observe(p Progress) {
+	t.lock.Lock()
+	key := progressKey{direction: p.Direction, sha: p.SHA}
+	previous, exists := t.parcels[key]
+	if !exists {
+		t.total.Parcels++
+	}
+	if previous.Total > 0 {
+		t.total.Total -= previous.Total
+	}
+	if p.Total > 0 {
+		t.total.Total += p.Total
+	}
+	t.total.Transferred += p.Transferred - previous.Transferred
+	if previous.Done && !p.Done {
+		// The parcel is being transferred again, e.g. retrying after a failure
+		t.total.Completed--
+		if previous.Err != nil {
+			t.total.Failed--
+		}
+	}
+	if p.Done && !previous.Done {
+		t.total.Completed++
+		if p.Err != nil {
+			t.total.Failed++
+		}
+	}
+	t.parcels[key] = p
+	total := t.total
+	t.lock.Unlock()
+
+	if t.fn != nil {
+		t.fn(p, total)
+	}
+}
+
+// Progress returns the current combined progress
+func (t *ProgressTracker) Progress() AggregateProgress {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.total
+}
@@ -4,5 +4,10 @@ go 1.16
 
 require (
 	github.com/pelletier/go-toml v1.8.1
-	golang.org/x/net v0.0.0-20201224014010-6772e930b67b
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68
+	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,108 @@
+// Package fsutil contains filesystem helpers shared by the packages that persist data on disk, such
+// as atomic file writes and cross process file locks
+package fsutil
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLockTimeout is returned when a lock could not be acquired before the timeout expired
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+const lockRetryInterval = 50 * time.Millisecond
+
+// DefaultLockTimeout is a reasonable amount of time to wait for a lock held by another process
+const DefaultLockTimeout = 30 * time.Second
+
+// ValidSHA256 reports whether sha is a SHA256 encoded as 64 lowercase hex characters. Anything else
+// must not be used as a file name, as it could point outside of the intended directory
+func ValidSHA256(sha string) bool {
+	if len(sha) != sha256.Size*2 {
+		return false
+	}
+	for _, r := range sha {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteFileAtomic writes data to the given path by first writing it to a temporary file in the
+// same directory and then renaming it into place, so readers never see a partially written file.
+// Any missing parent directories are created
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// This is a no-op once the rename succeeds
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Lock takes an exclusive lock on the given path using an OS advisory lock (`flock` or
+// `LockFileEx`) on a `<path>.lock` file next to it, waiting up to timeout for any other holder to
+// release it. The OS releases the lock if the holding process dies, so there are never stale locks
+// to clean up. The lock file itself is left in place, as removing it would let another process
+// lock a new file while the old one is still held. The returned function releases the lock
+func Lock(path string, timeout time.Duration) (func() error, error) {
+	lockPath := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			return func() error {
+				unlockErr := unlockFile(file)
+				if err := file.Close(); unlockErr == nil {
+					unlockErr = err
+				}
+				return unlockErr
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
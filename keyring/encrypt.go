@@ -0,0 +1,275 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/deislabs/go-bindle/internal/fsutil"
+	"github.com/pelletier/go-toml"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	encryptedKeyVersion = 1
+	kdfScrypt           = "scrypt"
+	cipherAES256GCM     = "aes-256-gcm"
+
+	// These are the recommended scrypt parameters for interactive logins as of 2017
+	scryptN       = 32768
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+	aesKeyLen     = 32
+
+	// The parameters are read from the key file before anything is authenticated, so they are
+	// capped to stop a malicious file from making scrypt allocate a huge amount of memory. The memory
+	// scrypt needs (128 * N * r bytes) is capped as well as each parameter. These limits still allow
+	// much stronger settings than the defaults
+	scryptMaxN      = 1 << 20
+	scryptMaxR      = 16
+	scryptMaxP      = 4
+	scryptMaxMemory = 256 << 20
+)
+
+var ErrEncryptedPrivKey = errors.New("private key is encrypted, a passphrase is required")
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase or corrupted private key")
+var ErrUnsupportedEnvelope = errors.New("unsupported encrypted private key format")
+var ErrEmptyPassphrase = errors.New("passphrase cannot be empty")
+var ErrInvalidPrivKey = errors.New("private key is not a valid Ed25519 private key")
+
+// PassphraseFunc returns the passphrase used to encrypt or decrypt a private key. It is only called
+// when a passphrase is actually needed
+type PassphraseFunc func() ([]byte, error)
+
+// EnvPassphrase returns a PassphraseFunc that reads the passphrase from the given environment
+// variable, which is useful for unlocking keys in CI
+func EnvPassphrase(name string) PassphraseFunc {
+	return func() ([]byte, error) {
+		passphrase, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return []byte(passphrase), nil
+	}
+}
+
+// TerminalPassphrase returns a PassphraseFunc that interactively prompts for the passphrase on the
+// terminal without echoing it. The prompt is written to stderr
+func TerminalPassphrase(prompt string) PassphraseFunc {
+	return func() ([]byte, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, errors.New("cannot prompt for a passphrase, stdin is not a terminal")
+		}
+
+		fmt.Fprint(os.Stderr, prompt)
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+}
+
+// encryptedKeyEnvelope is the on disk format of an encrypted private key. The version field allows
+// the KDF and cipher to be changed in the future while still being able to read older files
+type encryptedKeyEnvelope struct {
+	Version    int    `toml:"version"`
+	KDF        string `toml:"kdf"`
+	Salt       string `toml:"salt"`
+	N          int    `toml:"n"`
+	R          int    `toml:"r"`
+	P          int    `toml:"p"`
+	Cipher     string `toml:"cipher"`
+	Nonce      string `toml:"nonce"`
+	Ciphertext string `toml:"ciphertext"`
+}
+
+// additionalData binds the KDF and cipher parameters to the ciphertext so they can't be tampered
+// with
+func (e *encryptedKeyEnvelope) additionalData() []byte {
+	return []byte(fmt.Sprintf("bindle-encrypted-key\n%d\n%s\n%d\n%d\n%d\n%s", e.Version, e.KDF, e.N, e.R, e.P, e.Cipher))
+}
+
+func (e *encryptedKeyEnvelope) aead(passphrase []byte) (cipher.AEAD, error) {
+	if e.Version != encryptedKeyVersion || e.KDF != kdfScrypt || e.Cipher != cipherAES256GCM {
+		return nil, ErrUnsupportedEnvelope
+	}
+	if !validScryptParams(e.N, e.R, e.P) {
+		return nil, fmt.Errorf("%w: scrypt parameters n=%d, r=%d, p=%d are out of range", ErrUnsupportedEnvelope, e.N, e.R, e.P)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(e.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(passphrase, salt, e.N, e.R, e.P, aesKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// validScryptParams checks that N is a power of two greater than 1 and that all parameters, and the
+// memory scrypt needs for them, are within the supported limits
+func validScryptParams(n, r, p int) bool {
+	return n > 1 && n <= scryptMaxN && n&(n-1) == 0 &&
+		r > 0 && r <= scryptMaxR &&
+		p > 0 && p <= scryptMaxP &&
+		128*int64(n)*int64(r) <= scryptMaxMemory
+}
+
+// EncryptPrivKey encrypts the private key with a key derived from the passphrase and returns the
+// encoded envelope, as written by `WriteEncryptedPrivKey`
+func EncryptPrivKey(privKey []byte, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	envelope := encryptedKeyEnvelope{
+		Version: encryptedKeyVersion,
+		KDF:     kdfScrypt,
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Cipher:  cipherAES256GCM,
+	}
+
+	aead, err := envelope.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	envelope.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	envelope.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, privKey, envelope.additionalData()))
+
+	return toml.Marshal(envelope)
+}
+
+// DecryptPrivKey decrypts an encrypted private key envelope created by `EncryptPrivKey`. Returns
+// `ErrIncorrectPassphrase` if the passphrase is wrong and `ErrInvalidPrivKey` if the decrypted data
+// isn't an Ed25519 private key
+func DecryptPrivKey(data []byte, passphrase []byte) ([]byte, error) {
+	var envelope encryptedKeyEnvelope
+	if err := toml.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+
+	aead, err := envelope.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(envelope.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrUnsupportedEnvelope
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	privKey, err := aead.Open(nil, nonce, ciphertext, envelope.additionalData())
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	if len(privKey) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidPrivKey
+	}
+
+	return privKey, nil
+}
+
+// IsEncryptedPrivKey returns true if the given private key file contents are an encrypted envelope
+// rather than a plaintext base64 encoded key
+func IsEncryptedPrivKey(data []byte) bool {
+	// Plaintext keys are a single base64 string, which never contains whitespace or quotes, so a
+	// TOML document can't be confused with one
+	trimmed := bytes.TrimSpace(data)
+	return bytes.ContainsAny(trimmed, " \n\"") && bytes.Contains(trimmed, []byte("ciphertext"))
+}
+
+// WriteEncryptedPrivKey writes a private key encrypted with the given passphrase to the provided
+// filepath. The key can be read back with `ReadPrivKeyWithPassphrase`
+func WriteEncryptedPrivKey(privKey []byte, filepath string, passphrase []byte) error {
+	data, err := EncryptPrivKey(privKey, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(filepath, data, 0600)
+}
+
+// ReadPrivKeyWithPassphrase reads a private key from a file that is either encrypted or plaintext
+// and returns its raw bytes. The passphrase function is only called if the key is encrypted
+func ReadPrivKeyWithPassphrase(filepath string, passphrase PassphraseFunc) ([]byte, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsEncryptedPrivKey(data) {
+		return base64.StdEncoding.DecodeString(string(data))
+	}
+
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptPrivKey(data, pass)
+}
+
+// EncryptPrivKeyFile migrates an existing plaintext private key file (as written by
+// `WritePrivKey`) to an encrypted one in place. Files that are already encrypted are left alone
+func EncryptPrivKeyFile(filepath string, passphrase PassphraseFunc) error {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+
+	if IsEncryptedPrivKey(data) {
+		return nil
+	}
+
+	privKey, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return err
+	}
+
+	pass, err := passphrase()
+	if err != nil {
+		return err
+	}
+
+	return WriteEncryptedPrivKey(privKey, filepath, pass)
+}
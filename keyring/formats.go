@@ -0,0 +1,292 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/deislabs/go-bindle/types"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	pemTypePrivateKey        = "PRIVATE KEY"
+	pemTypePublicKey         = "PUBLIC KEY"
+	pemTypeOpenSSHPrivateKey = "OPENSSH PRIVATE KEY"
+
+	openSSHKeyMagic = "openssh-key-v1\x00"
+)
+
+var ErrNotEd25519Key = errors.New("key is not an Ed25519 key")
+var ErrInvalidPEM = errors.New("no PEM data found")
+
+// ParsePKCS8PrivateKey parses a PEM encoded PKCS#8 ("PRIVATE KEY") Ed25519 private key, such as the
+// ones created by `openssl genpkey -algorithm ed25519`, and returns its raw bytes
+func ParsePKCS8PrivateKey(pemBytes []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != pemTypePrivateKey {
+		return nil, ErrInvalidPEM
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrNotEd25519Key
+	}
+
+	return privKey, nil
+}
+
+// MarshalPKCS8PrivateKey encodes the raw Ed25519 private key as a PEM encoded PKCS#8 private key
+func MarshalPKCS8PrivateKey(privKey []byte) ([]byte, error) {
+	if len(privKey) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidSecretKey
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(ed25519.PrivateKey(privKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+}
+
+// ParsePKIXPublicKey parses a PEM encoded PKIX ("PUBLIC KEY") Ed25519 public key and returns it
+// base64 encoded, as used in `SignatureKey.Key`
+func ParsePKIXPublicKey(pemBytes []byte) (string, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != pemTypePublicKey {
+		return "", ErrInvalidPEM
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	pubKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return "", ErrNotEd25519Key
+	}
+
+	return base64.StdEncoding.EncodeToString(pubKey), nil
+}
+
+// MarshalPKIXPublicKey encodes the base64 encoded public key (as used in `SignatureKey.Key`) as a
+// PEM encoded PKIX public key
+func MarshalPKIXPublicKey(pubKey string) ([]byte, error) {
+	key, err := decodePublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePublicKey, Bytes: der}), nil
+}
+
+// ParseOpenSSHPrivateKey parses an OpenSSH Ed25519 private key (such as `~/.ssh/id_ed25519`) and
+// returns its raw bytes. If the key is protected with a passphrase, the passphrase function is
+// called to get it. The passphrase function can be nil if the key is known to be unprotected
+func ParseOpenSSHPrivateKey(data []byte, passphrase PassphraseFunc) ([]byte, error) {
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == nil {
+			return nil, ErrEncryptedPrivKey
+		}
+		pass, passErr := passphrase()
+		if passErr != nil {
+			return nil, passErr
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, pass)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *ed25519.PrivateKey:
+		return *k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+
+	return nil, ErrNotEd25519Key
+}
+
+// MarshalOpenSSHPrivateKey encodes the raw Ed25519 private key in the (unencrypted) OpenSSH private
+// key format with the given comment, so it can be used with SSH tooling
+func MarshalOpenSSHPrivateKey(privKey []byte, comment string) ([]byte, error) {
+	if len(privKey) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidSecretKey
+	}
+
+	pubKey, err := ssh.NewPublicKey(ed25519.PrivateKey(privKey).Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// See the PROTOCOL.key file in the OpenSSH source for a description of this format
+	var checkBytes [4]byte
+	if _, err := rand.Read(checkBytes[:]); err != nil {
+		return nil, err
+	}
+	check := binary.BigEndian.Uint32(checkBytes[:])
+
+	var private bytes.Buffer
+	writeUint32(&private, check)
+	writeUint32(&private, check)
+	writeSSHString(&private, []byte(ssh.KeyAlgoED25519))
+	writeSSHString(&private, ed25519.PrivateKey(privKey).Public().(ed25519.PublicKey))
+	writeSSHString(&private, privKey)
+	writeSSHString(&private, []byte(comment))
+	// Pad to the cipher block size, which is 8 for unencrypted keys
+	for i := 1; private.Len()%8 != 0; i++ {
+		private.WriteByte(byte(i))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(openSSHKeyMagic)
+	writeSSHString(&buf, []byte("none"))
+	writeSSHString(&buf, []byte("none"))
+	writeSSHString(&buf, nil)
+	writeUint32(&buf, 1)
+	writeSSHString(&buf, pubKey.Marshal())
+	writeSSHString(&buf, private.Bytes())
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypeOpenSSHPrivateKey, Bytes: buf.Bytes()}), nil
+}
+
+// ParseOpenSSHPublicKey parses an OpenSSH Ed25519 public key in authorized_keys format (such as
+// `~/.ssh/id_ed25519.pub`) and returns it base64 encoded, as used in `SignatureKey.Key`
+func ParseOpenSSHPublicKey(data []byte) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return "", err
+	}
+
+	if key.Type() != ssh.KeyAlgoED25519 {
+		return "", ErrNotEd25519Key
+	}
+
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return "", ErrNotEd25519Key
+	}
+	pubKey, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return "", ErrNotEd25519Key
+	}
+
+	return base64.StdEncoding.EncodeToString(pubKey), nil
+}
+
+// MarshalOpenSSHPublicKey encodes the base64 encoded public key (as used in `SignatureKey.Key`) in
+// authorized_keys format with the given comment
+func MarshalOpenSSHPublicKey(pubKey string, comment string) ([]byte, error) {
+	key, err := decodePublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sshKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	authorized := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(sshKey), []byte("\n"))
+	if comment != "" {
+		authorized = append(authorized, ' ')
+		authorized = append(authorized, comment...)
+	}
+
+	return append(authorized, '\n'), nil
+}
+
+// ImportPrivKeyFile reads an Ed25519 private key from a file in any of the supported formats: a
+// PKCS#8 PEM file, an OpenSSH private key or a bindle private key (as written by `WritePrivKey`
+// or `WriteEncryptedPrivKey`). The passphrase function is only called if the key is encrypted
+// and can be nil if the key is known to be unprotected
+func ImportPrivKeyFile(path string, passphrase PassphraseFunc) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case pemTypePrivateKey:
+			return ParsePKCS8PrivateKey(data)
+		case pemTypeOpenSSHPrivateKey:
+			return ParseOpenSSHPrivateKey(data, passphrase)
+		default:
+			return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+		}
+	}
+
+	if IsEncryptedPrivKey(data) {
+		if passphrase == nil {
+			return nil, ErrEncryptedPrivKey
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		return DecryptPrivKey(data, pass)
+	}
+
+	return base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+}
+
+// ImportPubKeyFile reads an Ed25519 public key from either a PKIX PEM file or an OpenSSH
+// authorized_keys format file and returns it base64 encoded, as used in `SignatureKey.Key`
+func ImportPubKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		return ParsePKIXPublicKey(data)
+	}
+
+	return ParseOpenSSHPublicKey(data)
+}
+
+func decodePublicKey(pubKey string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, types.ErrInvalidSignatureKey
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeSSHString(buf *bytes.Buffer, s []byte) {
+	writeUint32(buf, uint32(len(s)))
+	buf.Write(s)
+}
@@ -4,18 +4,20 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"os"
-	"path/filepath"
 
 	"github.com/deislabs/go-bindle/types"
-	"github.com/pelletier/go-toml"
 )
 
-// GenerateSignatureKey generates a keypair for signing Bindle invoices
+var ErrKeyMismatch = errors.New("private key does not belong to the signature key")
+
+// GenerateSignatureKey generates a keypair for signing Bindle invoices with the given roles. At
+// least one role is required and every role must be one of `types.ValidRoles`.
 // The return types are the public key (wrapped in a SignatureKey), the private key, and any error
-func GenerateSignatureKey(author, role string) (*types.SignatureKey, []byte, error) {
-	if exists, val := types.ValidRoles[role]; !exists || !val {
-		return nil, nil, types.ErrInvalidRole
+func GenerateSignatureKey(author string, roles ...string) (*types.SignatureKey, []byte, error) {
+	if err := types.ValidateRoles(roles); err != nil {
+		return nil, nil, err
 	}
 
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
@@ -30,7 +32,7 @@ func GenerateSignatureKey(author, role string) (*types.SignatureKey, []byte, err
 
 	sigKey := &types.SignatureKey{
 		Label:          author,
-		Roles:          []string{role},
+		Roles:          append([]string{}, roles...),
 		Key:            pubString,
 		LabelSignature: sigString,
 	}
@@ -38,51 +40,145 @@ func GenerateSignatureKey(author, role string) (*types.SignatureKey, []byte, err
 	return sigKey, priv, nil
 }
 
-// Localkeyring returns the keyring stored on your local machine
-func LocalKeyring() (*types.Keyring, error) {
-	filepath := keyringFilepath()
+// AddRoles adds the given roles to an existing signature key. The private key must belong to the
+// signature key and is used to re-sign the key's label, so the updated key can be distributed in
+// place of the old one. Roles the key already has are ignored
+func AddRoles(key *types.SignatureKey, privKey []byte, roles ...string) error {
+	if err := types.ValidateRoles(roles); err != nil {
+		return err
+	}
 
-	keyringBytes, err := os.ReadFile(filepath)
-	if err != nil {
-		return nil, err
+	newRoles := append([]string{}, key.Roles...)
+	for _, role := range roles {
+		if !key.IncludesRole(role) {
+			newRoles = append(newRoles, role)
+		}
+	}
+
+	return resignKey(key, privKey, newRoles)
+}
+
+// RemoveRoles removes the given roles from an existing signature key, re-signing the key's label
+// like `AddRoles`. A key must keep at least one role
+func RemoveRoles(key *types.SignatureKey, privKey []byte, roles ...string) error {
+	if err := types.ValidateRoles(roles); err != nil {
+		return err
+	}
+
+	remove := map[string]bool{}
+	for _, role := range roles {
+		remove[role] = true
+	}
+
+	newRoles := []string{}
+	for _, role := range key.Roles {
+		if !remove[role] {
+			newRoles = append(newRoles, role)
+		}
+	}
+
+	return resignKey(key, privKey, newRoles)
+}
+
+// resignKey validates the new roles and re-signs the key's label with the private key, which must
+// match the key's public key. The key is only modified if everything is valid
+func resignKey(key *types.SignatureKey, privKey []byte, roles []string) error {
+	if err := types.ValidateRoles(roles); err != nil {
+		return err
+	}
+
+	if len(privKey) != ed25519.PrivateKeySize {
+		return ErrInvalidSecretKey
+	}
+	priv := ed25519.PrivateKey(privKey)
+	if base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)) != key.Key {
+		return ErrKeyMismatch
 	}
 
-	keyring := &types.Keyring{}
-	if err := toml.Unmarshal(keyringBytes, keyring); err != nil {
+	key.Roles = roles
+	key.LabelSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(key.Label)))
+
+	return nil
+}
+
+// Localkeyring returns the keyring stored on your local machine. This is the keyring file of the
+// `DefaultStore`. To get the combined view of all keyrings used for verification, use
+// `LayeredKeyring` instead
+func LocalKeyring() (*types.Keyring, error) {
+	store, err := DefaultStore()
+	if err != nil {
 		return nil, err
 	}
 
-	return keyring, nil
+	return store.Load()
 }
 
-// AddLocalKey adds a new key to your local keyring file
+// AddLocalKey adds a new key to your local keyring file. If the public key is already in the
+// keyring, any new roles are merged into the existing entry rather than adding a duplicate
 func AddLocalKey(key *types.SignatureKey) error {
-	keyring, err := LocalKeyring()
+	store, err := DefaultStore()
 	if err != nil {
-		// nothing to be done, create a new one
+		return err
+	}
 
-		keyring = &types.Keyring{
-			Version: "1.0.0",
-			Key:     []types.SignatureKey{},
-		}
+	return store.Add(key)
+}
+
+// RemoveLocalKey removes the given base64 encoded public key from your local keyring file. Returns
+// `types.ErrKeyNotFound` if the key is not in the keyring
+func RemoveLocalKey(key string) error {
+	store, err := DefaultStore()
+	if err != nil {
+		return err
 	}
 
-	keyring.Key = append(keyring.Key, *key)
+	return store.Remove(key)
+}
 
-	keyringBytes, err := toml.Marshal(keyring)
+// RotateLocalKey replaces the given base64 encoded public key in your local keyring file with
+// newKey. Returns `types.ErrKeyNotFound` if the old key is not in the keyring
+func RotateLocalKey(oldKey string, newKey *types.SignatureKey) error {
+	store, err := DefaultStore()
 	if err != nil {
 		return err
 	}
 
-	// overwrite the file if it exists
-	if err := os.WriteFile(keyringFilepath(), keyringBytes, 0600); err != nil {
+	return store.Rotate(oldKey, newKey)
+}
+
+// MergeLocalKeys merges all keys from the given keyring into your local keyring file
+func MergeLocalKeys(other *types.Keyring) error {
+	store, err := DefaultStore()
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return store.Merge(other)
 }
 
-// WritePrivKey writes a private key (encoded to base64) to the provided filepath
+// UpdateLocalKeyring performs a `Store.Update` on your local keyring file
+func UpdateLocalKeyring(update func(keyring *types.Keyring) error) error {
+	store, err := DefaultStore()
+	if err != nil {
+		return err
+	}
+
+	return store.Update(update)
+}
+
+// TrustHostKeys adds the given host keys to your local keyring file. See `Store.TrustHostKeys` for
+// more details
+func TrustHostKeys(hostKeys *types.Keyring) ([]HostKeyChange, error) {
+	store, err := DefaultStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.TrustHostKeys(hostKeys)
+}
+
+// WritePrivKey writes a private key (encoded to base64) to the provided filepath. The key is stored
+// unencrypted, use `WriteEncryptedPrivKey` to protect it with a passphrase
 func WritePrivKey(privKey []byte, filepath string) error {
 	keyString := base64.StdEncoding.EncodeToString(privKey)
 
@@ -93,26 +189,17 @@ func WritePrivKey(privKey []byte, filepath string) error {
 	return nil
 }
 
-// ReadPrivKey reads a private key from a file and returns its raw bytes
+// ReadPrivKey reads a private key from a file and returns its raw bytes. Returns
+// `ErrEncryptedPrivKey` if the key is encrypted, use `ReadPrivKeyWithPassphrase` for those
 func ReadPrivKey(filepath string) ([]byte, error) {
 	keyBytes, err := os.ReadFile(filepath)
 	if err != nil {
 		return nil, err
 	}
 
-	return base64.StdEncoding.DecodeString(string(keyBytes))
-}
-
-func keyringFilepath() string {
-	base := filepath.Join("$HOME", ".bindle")
-
-	if home, err := os.UserHomeDir(); err == nil {
-		base = filepath.Join(home, ".bindle")
+	if IsEncryptedPrivKey(keyBytes) {
+		return nil, ErrEncryptedPrivKey
 	}
 
-	if config, err := os.UserConfigDir(); err == nil {
-		base = filepath.Join(config, "bindle")
-	}
-
-	return filepath.Join(base, "keyring.toml")
+	return base64.StdEncoding.DecodeString(string(keyBytes))
 }
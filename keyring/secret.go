@@ -0,0 +1,270 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/deislabs/go-bindle/internal/fsutil"
+	"github.com/deislabs/go-bindle/types"
+	"github.com/pelletier/go-toml"
+)
+
+const secretKeyStoreVersion = "1.0"
+
+var ErrSecretKeyNotFound = errors.New("no secret key matches the given label and role")
+var ErrInvalidSecretKey = errors.New("secret key is not valid")
+
+// SecretKeyEntry is a labeled signing keypair along with the roles it can sign for. The keypair is
+// the base64 encoded Ed25519 private key (which includes the public key)
+type SecretKeyEntry struct {
+	Label   string   `toml:"label"`
+	Keypair string   `toml:"keypair"`
+	Roles   []string `toml:"roles"`
+	// Key and LabelSignature are the matching `SignatureKey` fields. They are not part of the
+	// `label`/`keypair`/`roles` entries written by `ExportRust` and are filled in when missing
+	Key            string `toml:"key,omitempty"`
+	LabelSignature string `toml:"labelSignature,omitempty"`
+}
+
+// rustSecretKeyEntry is the entry format used for Rust style `secret_keys.toml` files
+type rustSecretKeyEntry struct {
+	Label   string   `toml:"label"`
+	Keypair string   `toml:"keypair"`
+	Roles   []string `toml:"roles"`
+}
+
+// NewSecretKeyEntry creates an entry for the given private key with the given label and roles
+func NewSecretKeyEntry(label string, privKey []byte, roles ...string) (*SecretKeyEntry, error) {
+	if len(privKey) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidSecretKey
+	}
+	if err := types.ValidateRoles(roles); err != nil {
+		return nil, err
+	}
+
+	entry := &SecretKeyEntry{
+		Label:   label,
+		Keypair: base64.StdEncoding.EncodeToString(privKey),
+		Roles:   append([]string{}, roles...),
+	}
+	if err := entry.fillPublic(); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// PrivateKey returns the raw private key of the entry
+func (e *SecretKeyEntry) PrivateKey() (ed25519.PrivateKey, error) {
+	keyBytes, err := base64.StdEncoding.DecodeString(e.Keypair)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyBytes) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidSecretKey
+	}
+
+	return ed25519.PrivateKey(keyBytes), nil
+}
+
+// SignatureKey returns the public `SignatureKey` for the entry, suitable for adding to a keyring
+func (e *SecretKeyEntry) SignatureKey() (*types.SignatureKey, error) {
+	if err := e.fillPublic(); err != nil {
+		return nil, err
+	}
+
+	return &types.SignatureKey{
+		Label:          e.Label,
+		Roles:          append([]string{}, e.Roles...),
+		Key:            e.Key,
+		LabelSignature: e.LabelSignature,
+	}, nil
+}
+
+// IncludesRole returns true if the entry can sign for the given role
+func (e *SecretKeyEntry) IncludesRole(role string) bool {
+	for _, r := range e.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fillPublic derives the public key and label signature from the keypair, making sure any existing
+// values actually belong to it
+func (e *SecretKeyEntry) fillPublic() error {
+	priv, err := e.PrivateKey()
+	if err != nil {
+		return err
+	}
+
+	pub := base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))
+	if e.Key != "" && e.Key != pub {
+		return fmt.Errorf("public key for %q does not match its keypair: %w", e.Label, ErrInvalidSecretKey)
+	}
+	e.Key = pub
+	// Signatures are deterministic with Ed25519, so this always matches a valid existing signature
+	e.LabelSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(e.Label)))
+
+	return nil
+}
+
+// SecretKeyStore is a collection of labeled signing keys. It is stored as a TOML file modeled on
+// the Rust bindle `secret_keys.toml` file, with some additional public key information. Use
+// `ImportRustSecretKeys` and `ExportRust` to read and write files without the additional fields.
+// These have not been tested against files written by the Rust bindle tooling
+type SecretKeyStore struct {
+	Version string           `toml:"version"`
+	Key     []SecretKeyEntry `toml:"key"`
+}
+
+type rustSecretKeyFile struct {
+	Version string               `toml:"version"`
+	Key     []rustSecretKeyEntry `toml:"key"`
+}
+
+// NewSecretKeyStore returns a new, empty SecretKeyStore
+func NewSecretKeyStore() *SecretKeyStore {
+	return &SecretKeyStore{
+		Version: secretKeyStoreVersion,
+		Key:     []SecretKeyEntry{},
+	}
+}
+
+// LoadSecretKeyStore loads the secret key store from the given path. The public key fields are
+// optional, so files written by `ExportRust` can be loaded as well
+func LoadSecretKeyStore(path string) (*SecretKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	store := NewSecretKeyStore()
+	if err := toml.Unmarshal(data, store); err != nil {
+		return nil, err
+	}
+
+	for i := range store.Key {
+		if err := store.Key[i].fillPublic(); err != nil {
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// ImportRustSecretKeys loads a `secret_keys.toml` file with only `label`, `keypair` and `roles` for
+// each key, such as one written by `ExportRust`
+func ImportRustSecretKeys(path string) (*SecretKeyStore, error) {
+	return LoadSecretKeyStore(path)
+}
+
+// Save writes the secret key store to the given path, readable only by the current user
+func (s *SecretKeyStore) Save(path string) error {
+	data, err := toml.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(path, data, 0600)
+}
+
+// ExportRust writes the secret key store to the given path with only the `label`, `keypair` and
+// `roles` of each key, the fields of the Rust bindle `secret_keys.toml` file
+func (s *SecretKeyStore) ExportRust(path string) error {
+	file := rustSecretKeyFile{
+		Version: secretKeyStoreVersion,
+		Key:     make([]rustSecretKeyEntry, 0, len(s.Key)),
+	}
+	for _, entry := range s.Key {
+		file.Key = append(file.Key, rustSecretKeyEntry{
+			Label:   entry.Label,
+			Keypair: entry.Keypair,
+			Roles:   entry.Roles,
+		})
+	}
+
+	data, err := toml.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(path, data, 0600)
+}
+
+// Add adds a keypair with the given label and roles to the store. If the keypair is already in the
+// store, any new roles are added to the existing entry instead
+func (s *SecretKeyStore) Add(label string, privKey []byte, roles ...string) (*SecretKeyEntry, error) {
+	entry, err := NewSecretKeyEntry(label, privKey, roles...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range s.Key {
+		existing := &s.Key[i]
+		if existing.Keypair != entry.Keypair {
+			continue
+		}
+		for _, role := range entry.Roles {
+			if !existing.IncludesRole(role) {
+				existing.Roles = append(existing.Roles, role)
+			}
+		}
+		return existing, nil
+	}
+
+	s.Key = append(s.Key, *entry)
+	return &s.Key[len(s.Key)-1], nil
+}
+
+// Remove removes the entry for the given base64 encoded public key. Returns false if there is no
+// such entry
+func (s *SecretKeyStore) Remove(key string) bool {
+	for i := range s.Key {
+		if s.Key[i].Key == key {
+			s.Key = append(s.Key[:i], s.Key[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Select returns the first entry with the given label that can sign for the given role. An empty
+// label matches any entry. Returns `ErrSecretKeyNotFound` if no entry matches
+func (s *SecretKeyStore) Select(label, role string) (*SecretKeyEntry, error) {
+	for i := range s.Key {
+		entry := &s.Key[i]
+		if (label == "" || entry.Label == label) && entry.IncludesRole(role) {
+			return entry, nil
+		}
+	}
+
+	return nil, ErrSecretKeyNotFound
+}
+
+// SignInvoice signs the invoice for the given role with the entry selected by label and role (see
+// `Select`). The entry's label is used as the signing author
+func (s *SecretKeyStore) SignInvoice(inv *types.Invoice, label, role string) error {
+	entry, err := s.Select(label, role)
+	if err != nil {
+		return err
+	}
+
+	sigKey, err := entry.SignatureKey()
+	if err != nil {
+		return err
+	}
+
+	privKey, err := entry.PrivateKey()
+	if err != nil {
+		return err
+	}
+
+	return inv.GenerateSignature(entry.Label, role, sigKey, privKey)
+}
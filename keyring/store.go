@@ -0,0 +1,310 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/deislabs/go-bindle/internal/fsutil"
+	"github.com/deislabs/go-bindle/types"
+	"github.com/pelletier/go-toml"
+)
+
+// KeyringEnvVar is the environment variable that can be set to override the path of the default
+// keyring file
+const KeyringEnvVar = "BINDLE_KEYRING"
+
+const keyringFilename = "keyring.toml"
+
+// Store is a keyring file stored at a specific path. All modifications to the keyring are done
+// while holding a lock on the file and the file is replaced atomically, so it is safe to use the
+// same keyring file from concurrent processes
+type Store struct {
+	Path string
+}
+
+// NewStore returns a Store for the keyring file at the given path. The file does not need to exist
+// yet, it is created the first time the keyring is modified
+func NewStore(path string) *Store {
+	return &Store{Path: path}
+}
+
+// DefaultStore returns the Store for your local keyring. This is the path set in the
+// `BINDLE_KEYRING` environment variable if it is set, otherwise the user keyring (see
+// `UserKeyringPath`)
+func DefaultStore() (*Store, error) {
+	if path, ok := os.LookupEnv(KeyringEnvVar); ok && path != "" {
+		return NewStore(path), nil
+	}
+
+	path, err := UserKeyringPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStore(path), nil
+}
+
+// SystemKeyringPath returns the path to the keyring shared by all users of the machine. This is
+// `/etc/bindle/keyring.toml` on Unix systems and `%ProgramData%\bindle\keyring.toml` on Windows
+func SystemKeyringPath() string {
+	if runtime.GOOS == "windows" {
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, "bindle", keyringFilename)
+	}
+
+	return filepath.Join("/etc", "bindle", keyringFilename)
+}
+
+// UserKeyringPath returns the path to the current user's keyring. This is `bindle/keyring.toml`
+// in the user's config directory (e.g. `$XDG_CONFIG_HOME`), falling back to `.bindle/keyring.toml`
+// in the user's home directory if there is no config directory
+func UserKeyringPath() (string, error) {
+	if config, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(config, "bindle", keyringFilename), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine the user keyring location: %w", err)
+	}
+
+	return filepath.Join(home, ".bindle", keyringFilename), nil
+}
+
+// ProjectKeyringPath returns the path to a project specific keyring, which is
+// `.bindle/keyring.toml` within the given project directory
+func ProjectKeyringPath(dir string) string {
+	return filepath.Join(dir, ".bindle", keyringFilename)
+}
+
+// DefaultLayers returns the keyring stores that make up the layered keyring, from the most general
+// to the most specific: the system keyring and your local keyring (see `DefaultStore`). The project
+// keyring in projectDir is only added if projectDir is not empty. Keyrings are never picked up from
+// the current directory automatically, as anyone who can write to a cloned repository could then
+// plant trusted keys. Only pass a project directory you trust
+func DefaultLayers(projectDir string) ([]*Store, error) {
+	layers := []*Store{NewStore(SystemKeyringPath())}
+
+	local, err := DefaultStore()
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, local)
+
+	if projectDir != "" {
+		layers = append(layers, NewStore(ProjectKeyringPath(projectDir)))
+	}
+
+	return layers, nil
+}
+
+// LayeredKeyring returns the merged view of the given keyring stores, from the most general to the
+// most specific. Stores whose keyring file doesn't exist are skipped. If no stores are given, the
+// `DefaultLayers` without a project keyring are used. More specific layers can add keys, but can't
+// add roles to a key that is already in a more general layer, so a user or project keyring can't
+// widen what a system key is trusted for
+//
+// The result is the keyring to verify with, e.g. by passing its `Key` to
+// `Invoice.VerifySignatures` or the keyring itself to `client.WithHostVerification`
+func LayeredKeyring(stores ...*Store) (*types.Keyring, error) {
+	if len(stores) == 0 {
+		layers, err := DefaultLayers("")
+		if err != nil {
+			return nil, err
+		}
+		stores = layers
+	}
+
+	merged := newKeyring()
+	for _, store := range stores {
+		keyring, err := store.Load()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to load keyring %s: %w", store.Path, err)
+		}
+
+		// Unlike `Keyring.Merge`, keys from more general layers keep their roles
+		layerKeys := make(map[string]bool, len(merged.Key))
+		for _, key := range merged.Key {
+			layerKeys[key.Key] = true
+		}
+		for _, key := range keyring.Key {
+			if !layerKeys[key.Key] {
+				merged.Add(key)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// Load returns the keyring stored in the file. If the file doesn't exist, the returned error will
+// satisfy `errors.Is(err, os.ErrNotExist)`
+func (s *Store) Load() (*types.Keyring, error) {
+	keyringBytes, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := &types.Keyring{}
+	if err := toml.Unmarshal(keyringBytes, keyring); err != nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
+
+// Update loads the keyring (or a new empty one if the file doesn't exist yet), passes it to the
+// given function for modification and then saves it. The keyring file is locked for the duration
+// of the update. If the function returns an error, the keyring file is left untouched. Any
+// duplicate keys are removed before saving
+func (s *Store) Update(update func(keyring *types.Keyring) error) error {
+	unlock, err := fsutil.Lock(s.Path, fsutil.DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keyring, err := s.Load()
+	if errors.Is(err, os.ErrNotExist) {
+		keyring = newKeyring()
+	} else if err != nil {
+		return err
+	}
+
+	if err := update(keyring); err != nil {
+		return err
+	}
+	keyring.Dedupe()
+
+	keyringBytes, err := toml.Marshal(keyring)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(s.Path, keyringBytes, 0600)
+}
+
+// Add adds a new key to the keyring. If the public key is already in the keyring, any new roles
+// are merged into the existing entry rather than adding a duplicate
+func (s *Store) Add(key *types.SignatureKey) error {
+	return s.Update(func(keyring *types.Keyring) error {
+		keyring.Add(*key)
+		return nil
+	})
+}
+
+// Remove removes the given base64 encoded public key from the keyring. Returns
+// `types.ErrKeyNotFound` if the key is not in the keyring
+func (s *Store) Remove(key string) error {
+	return s.Update(func(keyring *types.Keyring) error {
+		if !keyring.Remove(key) {
+			return types.ErrKeyNotFound
+		}
+		return nil
+	})
+}
+
+// Rotate replaces the given base64 encoded public key in the keyring with newKey. Returns
+// `types.ErrKeyNotFound` if the old key is not in the keyring
+func (s *Store) Rotate(oldKey string, newKey *types.SignatureKey) error {
+	if err := newKey.VerifyLabel(); err != nil {
+		return err
+	}
+
+	return s.Update(func(keyring *types.Keyring) error {
+		return keyring.Rotate(oldKey, *newKey)
+	})
+}
+
+// Merge merges all keys from the given keyring into the keyring
+func (s *Store) Merge(other *types.Keyring) error {
+	return s.Update(func(keyring *types.Keyring) error {
+		keyring.Merge(other)
+		return nil
+	})
+}
+
+// HostKeyChange describes a host key offered by a server whose label was already trusted with a
+// different key. The new key is not trusted automatically, as this may indicate a compromised or
+// impersonated server
+type HostKeyChange struct {
+	Label      string
+	TrustedKey string
+	NewKey     string
+}
+
+func (h HostKeyChange) String() string {
+	return fmt.Sprintf("host key for %q changed (trusted %s, server offered %s)", h.Label, h.TrustedKey, h.NewKey)
+}
+
+// TrustHostKeys adds the given host keys (generally fetched with `Client.GetHostKeys`) to the
+// keyring using trust-on-first-use semantics. Keys for labels that have never been seen are added,
+// keys that are already trusted are left alone and keys that differ from a previously trusted key
+// with the same label are skipped and returned as changes so the caller can warn about them. Every
+// key must have a valid label signature or nothing is trusted
+func (s *Store) TrustHostKeys(hostKeys *types.Keyring) ([]HostKeyChange, error) {
+	for i := range hostKeys.Key {
+		if err := hostKeys.Key[i].VerifyLabel(); err != nil {
+			return nil, fmt.Errorf("invalid host key %q: %w", hostKeys.Key[i].Label, err)
+		}
+	}
+
+	var changes []HostKeyChange
+	err := s.Update(func(keyring *types.Keyring) error {
+		changes = trustHostKeys(keyring, hostKeys)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+func trustHostKeys(keyring *types.Keyring, hostKeys *types.Keyring) []HostKeyChange {
+	var changes []HostKeyChange
+	for _, hostKey := range hostKeys.Key {
+		trusted := false
+		var changed *HostKeyChange
+		for i := range keyring.Key {
+			existing := &keyring.Key[i]
+			if existing.Key == hostKey.Key {
+				trusted = true
+				break
+			}
+			if existing.Label == hostKey.Label && existing.IncludesRole(types.RoleHost) {
+				changed = &HostKeyChange{
+					Label:      hostKey.Label,
+					TrustedKey: existing.Key,
+					NewKey:     hostKey.Key,
+				}
+			}
+		}
+
+		switch {
+		case trusted:
+			continue
+		case changed != nil:
+			changes = append(changes, *changed)
+		default:
+			keyring.Add(hostKey)
+		}
+	}
+
+	return changes
+}
+
+func newKeyring() *types.Keyring {
+	return &types.Keyring{
+		Version: "1.0.0",
+		Key:     []types.SignatureKey{},
+	}
+}
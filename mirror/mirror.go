@@ -0,0 +1,333 @@
+// Package mirror copies bindles between Bindle servers, e.g. from a primary server to regional
+// replicas. Invoices are copied as is, so all of their signatures are preserved, and only the
+// parcels the destination reports as missing are uploaded. Since everything already on the
+// destination is skipped, an interrupted sync can be resumed by running it again
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// DefaultConcurrency is the number of bindles and parcels copied at the same time by default
+const DefaultConcurrency = 4
+
+// Mirror copies bindles from a source server to a destination server
+type Mirror struct {
+	source      *client.Client
+	dest        *client.Client
+	concurrency int
+	progress    client.ProgressFunc
+}
+
+// Option configures optional behavior of a Mirror. Options are passed to `New`
+type Option func(*Mirror)
+
+// WithConcurrency sets the maximum number of bindles and of parcels copied at the same time.
+// Defaults to `DefaultConcurrency`
+func WithConcurrency(n int) Option {
+	return func(m *Mirror) {
+		if n > 0 {
+			m.concurrency = n
+		}
+	}
+}
+
+// WithProgress reports the progress of every parcel copied to the destination to fn, as uploads.
+// For the combined progress of a sync, pass the `Observe` method of a `client.ProgressTracker` and
+// add the parcels from `Plan` to it with `Expect` before syncing
+func WithProgress(fn client.ProgressFunc) Option {
+	return func(m *Mirror) {
+		m.progress = fn
+	}
+}
+
+// New returns a Mirror copying bindles from source to dest
+func New(source *client.Client, dest *client.Client, opts ...Option) *Mirror {
+	m := &Mirror{source: source, dest: dest, concurrency: DefaultConcurrency}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// BindleSync describes what needs to be done (or was done) to sync a single bindle
+type BindleSync struct {
+	// ID is the ID of the bindle
+	ID string
+	// CreateInvoice is true if the invoice doesn't exist on the destination
+	CreateInvoice bool
+	// Yank is true if the invoice is yanked on the source but not on the destination
+	Yank bool
+	// Parcels are the parcels the destination is missing. When the invoice doesn't exist on the
+	// destination yet, all of its parcels are listed during planning, even though the destination
+	// may already have some of them from other bindles
+	Parcels []types.Label
+	// Err is set if planning or syncing the bindle failed
+	Err error
+
+	invoice *types.Invoice
+}
+
+// UpToDate returns true if the bindle exists on the destination with all of its parcels
+func (b *BindleSync) UpToDate() bool {
+	return b.Err == nil && !b.CreateInvoice && !b.Yank && len(b.Parcels) == 0
+}
+
+// Result lists the sync of every requested bindle, in the order they were requested
+type Result struct {
+	Bindles []BindleSync
+}
+
+// Failed returns the bindles that couldn't be planned or synced
+func (r *Result) Failed() []BindleSync {
+	var failed []BindleSync
+	for _, b := range r.Bindles {
+		if b.Err != nil {
+			failed = append(failed, b)
+		}
+	}
+	return failed
+}
+
+func (r *Result) err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Unable to sync %d of %d bindles, first error for %s: %w", len(failed), len(r.Bindles), failed[0].ID, failed[0].Err)
+}
+
+// Plan compares the given bindles on the source and destination without changing anything, i.e.
+// a dry run of `Sync`. An error is returned along with the result if any bindle couldn't be
+// planned
+func (m *Mirror) Plan(ids []string) (*Result, error) {
+	result := &Result{Bindles: make([]BindleSync, len(ids))}
+	m.forEach(len(ids), func(i int) {
+		result.Bindles[i] = m.plan(ids[i])
+	})
+	return result, result.err()
+}
+
+// Sync copies the given bindles from the source to the destination. Bindles are synced
+// concurrently and a failure to sync one bindle doesn't stop the others. An error is returned
+// along with the result if any bindle couldn't be synced
+func (m *Mirror) Sync(ids []string) (*Result, error) {
+	copier := &parcelCopier{
+		mirror:   m,
+		inflight: map[string]*parcelCopy{},
+		sem:      make(chan struct{}, m.concurrency),
+	}
+
+	result := &Result{Bindles: make([]BindleSync, len(ids))}
+	m.forEach(len(ids), func(i int) {
+		b := m.plan(ids[i])
+		if b.Err == nil {
+			b.Err = m.sync(&b, copier)
+		}
+		result.Bindles[i] = b
+	})
+	return result, result.err()
+}
+
+// PlanQuery is the same as `Plan` for all bindles on the source matching the query
+func (m *Mirror) PlanQuery(opts types.QueryOptions) (*Result, error) {
+	ids, err := QueryIDs(m.source, opts)
+	if err != nil {
+		return nil, err
+	}
+	return m.Plan(ids)
+}
+
+// SyncQuery is the same as `Sync` for all bindles on the source matching the query
+func (m *Mirror) SyncQuery(opts types.QueryOptions) (*Result, error) {
+	ids, err := QueryIDs(m.source, opts)
+	if err != nil {
+		return nil, err
+	}
+	return m.Sync(ids)
+}
+
+// QueryIDs returns the IDs of all invoices matching the query, fetching every page of results.
+// The offset in the options is used as the starting point
+func QueryIDs(c *client.Client, opts types.QueryOptions) ([]string, error) {
+	var offset uint64
+	if opts.Offset != nil {
+		offset = *opts.Offset
+	}
+
+	var ids []string
+	for {
+		page := opts
+		page.Offset = &offset
+		matches, err := c.QueryInvoices(page)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to query source: %w", err)
+		}
+		for _, inv := range matches.Invoices {
+			ids = append(ids, inv.Name())
+		}
+		if !matches.More || len(matches.Invoices) == 0 {
+			return ids, nil
+		}
+		offset += uint64(len(matches.Invoices))
+	}
+}
+
+// forEach calls fn for every index from 0 to n, running up to the configured concurrency at once
+func (m *Mirror) forEach(n int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < m.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+func (m *Mirror) plan(id string) BindleSync {
+	b := BindleSync{ID: id}
+	inv, err := m.source.GetYankedInvoice(id)
+	if err != nil {
+		b.Err = fmt.Errorf("Unable to get invoice from source: %w", err)
+		return b
+	}
+	b.invoice = inv
+	yanked := inv.Yanked != nil && *inv.Yanked
+
+	missing, err := m.dest.GetMissingParcels(id)
+	if errors.Is(err, client.ErrNotFound) {
+		b.CreateInvoice = true
+		b.Yank = yanked
+		for _, p := range inv.Parcel {
+			b.Parcels = append(b.Parcels, p.Label)
+		}
+		return b
+	} else if err != nil {
+		b.Err = fmt.Errorf("Unable to check destination for missing parcels: %w", err)
+		return b
+	}
+	b.Parcels = missing.Missing
+
+	if yanked {
+		// GetInvoice only succeeds if the invoice isn't yanked on the destination
+		if _, err := m.dest.GetInvoice(id); err == nil {
+			b.Yank = true
+		}
+	}
+	return b
+}
+
+func (m *Mirror) sync(b *BindleSync, copier *parcelCopier) error {
+	if b.CreateInvoice {
+		inv := *b.invoice
+		// The destination decides whether the invoice is yanked, which is synced below
+		inv.Yanked = nil
+		resp, err := m.dest.CreateInvoice(inv)
+		if errors.Is(err, client.ErrConflict) {
+			// Another sync created it in the meantime, so ask again what is missing
+			missing, err := m.dest.GetMissingParcels(b.ID)
+			if err != nil {
+				return fmt.Errorf("Unable to check destination for missing parcels: %w", err)
+			}
+			b.Parcels = missing.Missing
+		} else if err != nil {
+			return fmt.Errorf("Unable to create invoice on destination: %w", err)
+		} else {
+			b.Parcels = resp.Missing
+		}
+	}
+
+	errs := make([]error, len(b.Parcels))
+	var wg sync.WaitGroup
+	for i := range b.Parcels {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = copier.copy(b.ID, b.Parcels[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.Yank {
+		if err := m.dest.YankInvoice(b.ID); err != nil {
+			return fmt.Errorf("Unable to yank invoice on destination: %w", err)
+		}
+	}
+	return nil
+}
+
+// parcelCopier copies parcels with limited concurrency, making sure a parcel shared by several
+// bindles is only copied once
+type parcelCopier struct {
+	mirror   *Mirror
+	lock     sync.Mutex
+	inflight map[string]*parcelCopy
+	sem      chan struct{}
+}
+
+type parcelCopy struct {
+	done chan struct{}
+	err  error
+}
+
+func (p *parcelCopier) copy(bindleID string, label types.Label) error {
+	sha := label.SHA256
+	p.lock.Lock()
+	existing, ok := p.inflight[sha]
+	if ok {
+		p.lock.Unlock()
+		<-existing.done
+		return existing.err
+	}
+	current := &parcelCopy{done: make(chan struct{})}
+	p.inflight[sha] = current
+	p.lock.Unlock()
+
+	p.sem <- struct{}{}
+	current.err = p.mirror.copyParcel(bindleID, label)
+	<-p.sem
+	close(current.done)
+	return current.err
+}
+
+func (m *Mirror) copyParcel(bindleID string, label types.Label) error {
+	sha := label.SHA256
+	data, err := m.source.GetParcelReader(bindleID, sha)
+	if err != nil {
+		err = fmt.Errorf("Unable to get parcel %s from source: %w", sha, err)
+		if m.progress != nil {
+			m.progress(client.Progress{Direction: client.Upload, BindleID: bindleID, SHA: sha, Total: int64(label.Size), Done: true, Err: err})
+		}
+		return err
+	}
+
+	body := client.NewProgressReader(data, client.Progress{Direction: client.Upload, BindleID: bindleID, SHA: sha, Total: int64(label.Size)}, m.progress)
+	// CreateParcelFromReader closes the reader
+	err = m.dest.CreateParcelFromReader(bindleID, sha, body)
+	if err != nil && !errors.Is(err, client.ErrConflict) {
+		err = fmt.Errorf("Unable to upload parcel %s to destination: %w", sha, err)
+		body.Finish(err)
+		return err
+	}
+	body.Finish(nil)
+	return nil
+}
@@ -0,0 +1,284 @@
+// Package proxy implements a caching, read-through Bindle proxy. It serves the Bindle API to local
+// consumers from a local storage provider and fetches anything it doesn't have from an upstream
+// Bindle server. Parcels fetched from upstream are verified against their SHA before they are
+// stored and invoices can be signed with a `proxy` role key so consumers know they were relayed
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/server"
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// DefaultYankCheckInterval is how often a locally stored invoice is checked for a yank upstream by
+// default
+const DefaultYankCheckInterval = time.Minute
+
+// Provider is a `storage.Provider` that reads through to an upstream Bindle server. Invoices and
+// parcels are served from the local provider when possible and fetched from upstream (and stored
+// locally) otherwise. Writes are forwarded to the upstream server. Invoices are immutable apart
+// from being yanked, so a locally stored invoice is checked upstream for a yank at most once per
+// yank check interval (see `WithYankCheckInterval`). If upstream can't be reached, the local copy
+// is served as is
+type Provider struct {
+	local    storage.Provider
+	upstream *client.Client
+
+	proxyKey  *types.SignatureKey
+	proxyPriv []byte
+
+	yankCheckInterval time.Duration
+	lock              sync.Mutex
+	// yankChecked is when each stored invoice was last checked for a yank upstream
+	yankChecked map[string]time.Time
+}
+
+// Option configures optional behavior of a Provider. Options are passed to `New` or `NewProvider`
+type Option func(*Provider)
+
+// WithProxyKey sets the key used to add a `proxy` signature to every invoice relayed from upstream.
+// The key must have the proxy role. When used with `New`, the public key is also served from the
+// bindle-keys endpoint
+func WithProxyKey(proxyKey *types.SignatureKey, privKey []byte) Option {
+	return func(p *Provider) {
+		p.proxyKey = proxyKey
+		p.proxyPriv = privKey
+	}
+}
+
+// WithYankCheckInterval sets how often a locally stored invoice is checked for a yank upstream. If
+// 0, it is checked on every request. Defaults to `DefaultYankCheckInterval`. Note that the upstream
+// client must not serve invoices from a cache for yanks to be seen
+func WithYankCheckInterval(interval time.Duration) Option {
+	return func(p *Provider) {
+		p.yankCheckInterval = interval
+	}
+}
+
+// NewProvider returns a Provider storing data in local and fetching misses from upstream
+func NewProvider(local storage.Provider, upstream *client.Client, opts ...Option) *Provider {
+	p := &Provider{
+		local:             local,
+		upstream:          upstream,
+		yankCheckInterval: DefaultYankCheckInterval,
+		yankChecked:       map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// New returns a `server.Server` serving the Bindle API from a read-through Provider. Queries are
+// forwarded to the upstream server
+func New(local storage.Provider, upstream *client.Client, opts ...Option) *server.Server {
+	p := NewProvider(local, upstream, opts...)
+	serverOpts := []server.Option{server.WithSearch(&upstreamSearch{provider: p})}
+	if p.proxyKey != nil {
+		serverOpts = append(serverOpts, server.WithPublicKeys(*p.proxyKey))
+	}
+	return server.New(p, serverOpts...)
+}
+
+// CreateInvoice implements `storage.Provider` by creating the invoice upstream
+func (p *Provider) CreateInvoice(inv *types.Invoice) ([]types.Label, error) {
+	resp, err := p.upstream.CreateInvoice(*inv)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return resp.Missing, nil
+}
+
+// GetInvoice implements `storage.Provider`
+func (p *Provider) GetInvoice(id string) (*types.Invoice, error) {
+	// Yanked invoices are fetched and stored too, so the yank is known locally afterwards
+	inv, err := p.GetYankedInvoice(id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Yanked != nil && *inv.Yanked {
+		return nil, fmt.Errorf("%s: %w", id, storage.ErrYanked)
+	}
+	return inv, nil
+}
+
+// GetYankedInvoice implements `storage.Provider`
+func (p *Provider) GetYankedInvoice(id string) (*types.Invoice, error) {
+	inv, err := p.local.GetYankedInvoice(id)
+	if err == nil {
+		return p.checkYank(id, inv)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	inv, err = p.upstream.GetYankedInvoice(id)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	p.markYankChecked(id)
+	return p.store(inv)
+}
+
+// checkYank checks upstream whether a locally stored invoice has been yanked, unless it was checked
+// within the yank check interval. A yank upstream is stored locally and the yanked invoice returned
+func (p *Provider) checkYank(id string, inv *types.Invoice) (*types.Invoice, error) {
+	// Yanks can't be undone, so yanked invoices never need to be checked again
+	if inv.Yanked != nil && *inv.Yanked {
+		return inv, nil
+	}
+	p.lock.Lock()
+	checked, ok := p.yankChecked[id]
+	p.lock.Unlock()
+	if ok && time.Since(checked) < p.yankCheckInterval {
+		return inv, nil
+	}
+
+	upstreamInv, err := p.upstream.GetYankedInvoice(id)
+	if err != nil {
+		// Keep serving the local copy while upstream is unavailable, it is checked again next time
+		return inv, nil
+	}
+	p.markYankChecked(id)
+	if upstreamInv.Yanked == nil || !*upstreamInv.Yanked {
+		return inv, nil
+	}
+	if err := p.local.YankInvoice(id); err != nil {
+		return nil, err
+	}
+	return p.local.GetYankedInvoice(id)
+}
+
+func (p *Provider) markYankChecked(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.yankChecked[id] = time.Now()
+}
+
+// YankInvoice implements `storage.Provider` by yanking the invoice upstream and locally
+func (p *Provider) YankInvoice(id string) error {
+	if err := p.upstream.YankInvoice(id); err != nil {
+		return upstreamError(err)
+	}
+	if err := p.local.YankInvoice(id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// CreateParcel implements `storage.Provider` by uploading the parcel upstream
+func (p *Provider) CreateParcel(bindleID string, sha string, data io.Reader) error {
+	if err := p.upstream.CreateParcelFromReader(bindleID, sha, ioutil.NopCloser(data)); err != nil {
+		return upstreamError(err)
+	}
+	return nil
+}
+
+// GetParcel implements `storage.Provider`. Parcels fetched from upstream are stored locally first,
+// which verifies them against the SHA and size from their label
+func (p *Provider) GetParcel(bindleID string, sha string) (io.ReadCloser, error) {
+	// Make sure the invoice is stored locally, the local provider needs it to store the parcel
+	if _, err := p.GetYankedInvoice(bindleID); err != nil {
+		return nil, err
+	}
+
+	data, err := p.local.GetParcel(bindleID, sha)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return data, err
+	}
+
+	upstreamData, err := p.upstream.GetParcelReader(bindleID, sha)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	defer upstreamData.Close()
+	// Another request may have stored the parcel in the meantime, which is fine
+	if err := p.local.CreateParcel(bindleID, sha, upstreamData); err != nil && !errors.Is(err, storage.ErrAlreadyExists) {
+		return nil, fmt.Errorf("unable to store parcel %s from upstream: %w", sha, err)
+	}
+	return p.local.GetParcel(bindleID, sha)
+}
+
+// MissingParcels implements `storage.Provider` by asking the upstream server
+func (p *Provider) MissingParcels(bindleID string) ([]types.Label, error) {
+	missing, err := p.upstream.GetMissingParcels(bindleID)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return missing.Missing, nil
+}
+
+// store signs an invoice fetched from upstream and stores it locally
+func (p *Provider) store(inv *types.Invoice) (*types.Invoice, error) {
+	if err := p.sign(inv); err != nil {
+		return nil, err
+	}
+
+	yanked := inv.Yanked != nil && *inv.Yanked
+	if _, err := p.local.CreateInvoice(inv); err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			// Another request stored it in the meantime, so use that copy
+			return p.local.GetYankedInvoice(inv.Name())
+		}
+		return nil, fmt.Errorf("unable to store invoice %s from upstream: %w", inv.Name(), err)
+	}
+	if yanked {
+		if err := p.local.YankInvoice(inv.Name()); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// sign adds a proxy signature to the invoice if a proxy key is configured
+func (p *Provider) sign(inv *types.Invoice) error {
+	if p.proxyKey == nil {
+		return nil
+	}
+	if err := inv.GenerateSignature(p.proxyKey.Label, types.RoleProxy, p.proxyKey, p.proxyPriv); err != nil {
+		return fmt.Errorf("unable to sign invoice %s: %w", inv.Name(), err)
+	}
+	return nil
+}
+
+// upstreamError maps errors from the upstream server to the matching storage errors, so they are
+// passed on to consumers with the same status code
+func upstreamError(err error) error {
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return fmt.Errorf("upstream: %s: %w", err, storage.ErrNotFound)
+	case errors.Is(err, client.ErrConflict):
+		return fmt.Errorf("upstream: %s: %w", err, storage.ErrAlreadyExists)
+	}
+	return fmt.Errorf("upstream: %w", err)
+}
+
+// upstreamSearch forwards queries to the upstream server
+type upstreamSearch struct {
+	provider *Provider
+}
+
+// Index is a no-op, as invoices are indexed by the upstream server
+func (u *upstreamSearch) Index(inv *types.Invoice) error {
+	return nil
+}
+
+func (u *upstreamSearch) Query(opts types.QueryOptions) (*types.Matches, error) {
+	matches, err := u.provider.upstream.QueryInvoices(opts)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	for i := range matches.Invoices {
+		if err := u.provider.sign(&matches.Invoices[i]); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+const tomlMimeType = "application/toml"
+const jsonMimeType = "application/json"
+
+// errRequestTooLarge is returned by decodeRequest when the body is larger than the limit
+var errRequestTooLarge = errors.New("request body too large")
+
+// decodeRequest strictly decodes the JSON or TOML request body into v, refusing unknown fields like
+// the client does. Bodies larger than maxSize are refused with `errRequestTooLarge`
+func decodeRequest(w http.ResponseWriter, r *http.Request, maxSize int64, v interface{}) error {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+	if err != nil {
+		if int64(len(data)) >= maxSize {
+			return errRequestTooLarge
+		}
+		return err
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == jsonMimeType {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(v)
+	}
+	return toml.NewDecoder(bytes.NewReader(data)).Strict(true).Decode(v)
+}
+
+// writeResponse encodes the body as JSON if the client asked for it, otherwise as TOML
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	var buf bytes.Buffer
+	contentType := tomlMimeType
+	var err error
+	if strings.Contains(r.Header.Get("Accept"), jsonMimeType) {
+		contentType = jsonMimeType
+		err = json.NewEncoder(&buf).Encode(v)
+	} else {
+		err = toml.NewEncoder(&buf).Encode(v)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	io.Copy(w, &buf)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeResponse(w, r, status, types.ErrorResponse{Error: message})
+}
+
+// writeStoreError maps an error returned by a storage provider to the matching status code
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, storage.ErrYanked):
+		status = http.StatusForbidden
+	case errors.Is(err, storage.ErrAlreadyExists):
+		status = http.StatusConflict
+	case errors.Is(err, storage.ErrInvalidParcel), errors.Is(err, storage.ErrInvalidSHA):
+		status = http.StatusBadRequest
+	}
+	writeError(w, r, status, err.Error())
+}
+
+func parseQueryOptions(params url.Values) (types.QueryOptions, error) {
+	var opts types.QueryOptions
+	if q, ok := params["q"]; ok {
+		opts.Query = &q[0]
+	}
+	if v, ok := params["v"]; ok {
+		opts.Version = &v[0]
+	}
+	if raw := params.Get("o"); raw != "" {
+		offset, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid offset %q", raw)
+		}
+		opts.Offset = &offset
+	}
+	if raw := params.Get("l"); raw != "" {
+		limit, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil {
+			return opts, fmt.Errorf("invalid limit %q", raw)
+		}
+		l := uint8(limit)
+		opts.Limit = &l
+	}
+	if raw := params.Get("strict"); raw != "" {
+		strict, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid strict value %q", raw)
+		}
+		opts.Strict = &strict
+	}
+	if raw := params.Get("yanked"); raw != "" {
+		yanked, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid yanked value %q", raw)
+		}
+		opts.Yanked = &yanked
+	}
+	return opts, nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/deislabs/go-bindle/types"
+)
+
+type memorySearch struct {
+	lock     sync.RWMutex
+	invoices map[string]types.Invoice
+}
+
+// NewMemorySearch returns a Search keeping its index in memory. Queries are matched as described in
+// `types.QueryOptions.Search`
+func NewMemorySearch() Search {
+	return &memorySearch{invoices: map[string]types.Invoice{}}
+}
+
+func (m *memorySearch) Index(inv *types.Invoice) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.invoices[inv.Name()] = *inv
+	return nil
+}
+
+func (m *memorySearch) Query(opts types.QueryOptions) (*types.Matches, error) {
+	m.lock.RLock()
+	invoices := make([]types.Invoice, 0, len(m.invoices))
+	for _, inv := range m.invoices {
+		invoices = append(invoices, inv)
+	}
+	m.lock.RUnlock()
+
+	return opts.Search(invoices), nil
+}
@@ -0,0 +1,299 @@
+// Package server implements the Bindle HTTP API, so a Bindle server can be embedded directly in a
+// Go service instead of running bindle-server as a sidecar. Invoices and parcels are kept in a
+// `storage.Provider`, queries are answered by a pluggable `Search` and created invoices are signed
+// with the server's host key
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+)
+
+const (
+	invoicePath    = "/_i/"
+	queryPath      = "/_q"
+	missingPath    = "/_r/missing/"
+	bindleKeysPath = "/bindle-keys"
+)
+
+// DefaultMaxRequestSize is the default limit on the size of decoded request bodies such as
+// invoices. Parcel uploads are limited to the size in their label instead
+const DefaultMaxRequestSize = 10 << 20
+
+// Search answers queries against the invoices a Server stores. Implementations must be safe for
+// concurrent use
+type Search interface {
+	// Index adds the invoice to the search index, replacing any invoice with the same ID
+	Index(inv *types.Invoice) error
+	// Query returns the invoices matching the given options
+	Query(opts types.QueryOptions) (*types.Matches, error)
+}
+
+// Server is an `http.Handler` serving the Bindle API. The API is served from the root, so use
+// `http.StripPrefix` to mount it under a prefix like `/v1`
+type Server struct {
+	store    storage.Provider
+	search   Search
+	hostKey  *types.SignatureKey
+	hostPriv []byte
+	// publicKeys are served from the bindle-keys endpoint in addition to the host key
+	publicKeys     []types.SignatureKey
+	maxRequestSize int64
+}
+
+// Option configures optional behavior of a Server. Options are passed to `New`
+type Option func(*Server)
+
+// WithSearch sets the search provider used to answer queries. Defaults to a `NewMemorySearch`
+func WithSearch(search Search) Option {
+	return func(s *Server) {
+		s.search = search
+	}
+}
+
+// WithHostKey sets the key the server adds a `host` signature to every created invoice with. The
+// public key is also served from the bindle-keys endpoint. The key must have the host role
+func WithHostKey(hostKey *types.SignatureKey, privKey []byte) Option {
+	return func(s *Server) {
+		s.hostKey = hostKey
+		s.hostPriv = privKey
+	}
+}
+
+// WithPublicKeys adds keys to the ones served from the bindle-keys endpoint, such as the key a proxy
+// signs the invoices it relays with
+func WithPublicKeys(keys ...types.SignatureKey) Option {
+	return func(s *Server) {
+		s.publicKeys = append(s.publicKeys, keys...)
+	}
+}
+
+// WithMaxRequestSize sets the largest request body, such as an invoice, the server will decode.
+// Larger requests are refused with a 413 status. Defaults to `DefaultMaxRequestSize`
+func WithMaxRequestSize(size int64) Option {
+	return func(s *Server) {
+		s.maxRequestSize = size
+	}
+}
+
+// New returns a Server using the given storage provider and options. When using a persistent
+// provider, call `Reindex` to make previously stored invoices searchable
+func New(store storage.Provider, opts ...Option) *Server {
+	s := &Server{store: store, maxRequestSize: DefaultMaxRequestSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.search == nil {
+		s.search = NewMemorySearch()
+	}
+	return s
+}
+
+// Reindex adds every invoice in the storage provider to the search index. The provider must
+// implement `storage.Lister`
+func (s *Server) Reindex() error {
+	lister, ok := s.store.(storage.Lister)
+	if !ok {
+		return fmt.Errorf("storage provider %T cannot list invoices", s.store)
+	}
+	invoices, err := lister.ListInvoices()
+	if err != nil {
+		return err
+	}
+	for i := range invoices {
+		if err := s.search.Index(&invoices[i]); err != nil {
+			return fmt.Errorf("unable to index invoice %s: %w", invoices[i].Name(), err)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements `http.Handler`
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == strings.TrimSuffix(invoicePath, "/") && r.Method == http.MethodPost:
+		s.createInvoice(w, r)
+	case strings.HasPrefix(path, invoicePath):
+		id := strings.TrimPrefix(path, invoicePath)
+		if i := strings.LastIndex(id, "@"); i >= 0 {
+			s.parcel(w, r, id[:i], id[i+1:])
+			return
+		}
+		s.invoice(w, r, id)
+	case path == queryPath && r.Method == http.MethodGet:
+		s.query(w, r)
+	case strings.HasPrefix(path, missingPath) && r.Method == http.MethodGet:
+		s.missing(w, r, strings.TrimPrefix(path, missingPath))
+	case path == bindleKeysPath && r.Method == http.MethodGet:
+		s.keys(w, r)
+	default:
+		writeError(w, r, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) invoice(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		var inv *types.Invoice
+		var err error
+		if r.URL.Query().Get("yanked") == "true" {
+			inv, err = s.store.GetYankedInvoice(id)
+		} else {
+			inv, err = s.store.GetInvoice(id)
+		}
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		writeResponse(w, r, http.StatusOK, inv)
+	case http.MethodDelete:
+		if err := s.store.YankInvoice(id); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		inv, err := s.store.GetYankedInvoice(id)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if err := s.search.Index(inv); err != nil {
+			writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("unable to index invoice: %s", err))
+			return
+		}
+		writeResponse(w, r, http.StatusOK, map[string]string{"message": "invoice yanked"})
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) createInvoice(w http.ResponseWriter, r *http.Request) {
+	var inv types.Invoice
+	if err := decodeRequest(w, r, s.maxRequestSize, &inv); errors.Is(err, errRequestTooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("invoice is larger than %d bytes", s.maxRequestSize))
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid invoice: %s", err))
+		return
+	}
+	if inv.Bindle.Name == "" || inv.Bindle.Version == "" {
+		writeError(w, r, http.StatusBadRequest, "invoice must have a name and version")
+		return
+	}
+	inv.Yanked = nil
+
+	if s.hostKey != nil {
+		if err := inv.GenerateSignature(s.hostKey.Label, types.RoleHost, s.hostKey, s.hostPriv); err != nil {
+			writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("unable to sign invoice: %s", err))
+			return
+		}
+	}
+
+	missing, err := s.store.CreateInvoice(&inv)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if err := s.search.Index(&inv); err != nil {
+		writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("unable to index invoice: %s", err))
+		return
+	}
+
+	status := http.StatusCreated
+	if len(missing) > 0 {
+		status = http.StatusAccepted
+	}
+	writeResponse(w, r, status, types.InvoiceCreateResponse{Invoice: inv, Missing: missing})
+}
+
+func (s *Server) parcel(w http.ResponseWriter, r *http.Request, id string, sha string) {
+	// Parcels of yanked invoices can still be fetched, as clients may already depend on them
+	inv, err := s.store.GetYankedInvoice(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	label := storage.FindLabel(inv, sha)
+	if label == nil {
+		writeError(w, r, http.StatusNotFound, "parcel is not part of the invoice")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		data, err := s.store.GetParcel(id, sha)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		defer data.Close()
+		w.Header().Set("Content-Type", label.MediaType)
+		// Range requests can only be served if the data is seekable
+		if seeker, ok := data.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, "", time.Time{}, seeker)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", label.Size))
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, data)
+	case http.MethodPost:
+		// Uploads can't be larger than the label says, so refuse them before storing anything. The
+		// body is limited as well for uploads that don't declare their length
+		if r.ContentLength > int64(label.Size) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("parcel is larger than the %d bytes in its label", label.Size))
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, int64(label.Size)+1)
+		if err := s.store.CreateParcel(id, sha, body); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		writeResponse(w, r, http.StatusOK, *label)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseQueryOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	matches, err := s.search.Query(opts)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("unable to query invoices: %s", err))
+		return
+	}
+	writeResponse(w, r, http.StatusOK, matches)
+}
+
+func (s *Server) missing(w http.ResponseWriter, r *http.Request, id string) {
+	missing, err := s.store.MissingParcels(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, types.MissingParcelsResponse{Missing: missing})
+}
+
+func (s *Server) keys(w http.ResponseWriter, r *http.Request) {
+	keyring := types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{}}
+	if s.hostKey != nil {
+		keyring.Key = append(keyring.Key, *s.hostKey)
+	}
+	keyring.Key = append(keyring.Key, s.publicKeys...)
+
+	if roles := r.URL.Query().Get("roles"); roles != "" {
+		keyring.Key = keyring.List(strings.Split(roles, ",")...)
+	}
+	writeResponse(w, r, http.StatusOK, keyring)
+}
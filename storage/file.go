@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/deislabs/go-bindle/internal/fsutil"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+const (
+	invoiceDirectory = "invoices"
+	parcelDirectory  = "parcels"
+	invoiceFile      = "invoice.toml"
+	parcelFile       = "parcel.dat"
+	labelFile        = "label.toml"
+)
+
+// FileProvider is a Provider that stores invoices and parcels on disk following bindle-server's
+// layout, with each parcel's label stored next to its data:
+//
+//	<root>/invoices/<sha256 of the invoice ID>/invoice.toml
+//	<root>/parcels/<parcel SHA256>/label.toml
+//	<root>/parcels/<parcel SHA256>/parcel.dat
+//
+// The layout has not been checked against a data directory written by bindle-server itself, so
+// don't point both at the same directory
+//
+// Writes are atomic and guarded by lock files, so several processes can share the same directory
+type FileProvider struct {
+	// Root is the directory everything is stored under
+	Root string
+}
+
+// NewFileProvider returns a FileProvider storing data under the given root directory, creating it
+// if it doesn't exist
+func NewFileProvider(root string) (*FileProvider, error) {
+	for _, dir := range []string{invoiceDirectory, parcelDirectory} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, fmt.Errorf("unable to create storage directory: %w", err)
+		}
+	}
+	return &FileProvider{Root: root}, nil
+}
+
+// CreateInvoice implements Provider
+func (f *FileProvider) CreateInvoice(inv *types.Invoice) ([]types.Label, error) {
+	if err := validateLabels(inv); err != nil {
+		return nil, err
+	}
+
+	path := f.invoicePath(inv.Name())
+	unlock, err := fsutil.Lock(path, fsutil.DefaultLockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock invoice: %w", err)
+	}
+	defer unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("invoice %s %w", inv.Name(), ErrAlreadyExists)
+	}
+	if err := f.writeInvoice(path, inv); err != nil {
+		return nil, err
+	}
+	return f.missingLabels(inv), nil
+}
+
+// GetInvoice implements Provider
+func (f *FileProvider) GetInvoice(id string) (*types.Invoice, error) {
+	inv, err := f.GetYankedInvoice(id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Yanked != nil && *inv.Yanked {
+		return nil, fmt.Errorf("%s: %w", id, ErrYanked)
+	}
+	return inv, nil
+}
+
+// GetYankedInvoice implements Provider
+func (f *FileProvider) GetYankedInvoice(id string) (*types.Invoice, error) {
+	return f.readInvoice(f.invoicePath(id), id)
+}
+
+// YankInvoice implements Provider
+func (f *FileProvider) YankInvoice(id string) error {
+	path := f.invoicePath(id)
+	unlock, err := fsutil.Lock(path, fsutil.DefaultLockTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to lock invoice: %w", err)
+	}
+	defer unlock()
+
+	inv, err := f.readInvoice(path, id)
+	if err != nil {
+		return err
+	}
+	yanked := true
+	inv.Yanked = &yanked
+	return f.writeInvoice(path, inv)
+}
+
+// CreateParcel implements Provider. The data is streamed to a temporary file and only moved into
+// place once it has been verified against the label
+func (f *FileProvider) CreateParcel(bindleID string, sha string, data io.Reader) error {
+	label, err := findParcelLabel(f, bindleID, sha)
+	if err != nil {
+		return err
+	}
+
+	path, err := f.parcelPath(sha)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("parcel %s %w", sha, ErrAlreadyExists)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("unable to create parcel directory: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+parcelFile+".part*")
+	if err != nil {
+		return fmt.Errorf("unable to create parcel file: %w", err)
+	}
+	// This is a no-op once the rename succeeds
+	defer os.Remove(tmp.Name())
+
+	v := newVerifier()
+	_, err = io.Copy(io.MultiWriter(tmp, v), limitParcel(data, label))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write parcel data: %w", err)
+	}
+	if err := v.verify(label); err != nil {
+		return err
+	}
+
+	unlock, err := fsutil.Lock(path, fsutil.DefaultLockTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to lock parcel: %w", err)
+	}
+	defer unlock()
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("parcel %s %w", sha, ErrAlreadyExists)
+	}
+	// The label is written first, so a parcel's data never exists without its label
+	if err := f.writeLabel(filepath.Join(dir, labelFile), label); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to store parcel: %w", err)
+	}
+	return nil
+}
+
+// GetParcel implements Provider. The returned reader is an `*os.File`
+func (f *FileProvider) GetParcel(bindleID string, sha string) (io.ReadCloser, error) {
+	if _, err := findParcelLabel(f, bindleID, sha); err != nil {
+		return nil, err
+	}
+
+	path, err := f.parcelPath(sha)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("parcel %s %w", sha, ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open parcel: %w", err)
+	}
+	return file, nil
+}
+
+// MissingParcels implements Provider
+func (f *FileProvider) MissingParcels(bindleID string) ([]types.Label, error) {
+	inv, err := f.GetYankedInvoice(bindleID)
+	if err != nil {
+		return nil, err
+	}
+	return f.missingLabels(inv), nil
+}
+
+// ListInvoices implements Lister
+func (f *FileProvider) ListInvoices() ([]types.Invoice, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(f.Root, invoiceDirectory))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list invoices: %w", err)
+	}
+
+	var invoices []types.Invoice
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(f.Root, invoiceDirectory, entry.Name(), invoiceFile)
+		inv, err := f.readInvoice(path, entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			// Skip directories of invoices that are still being created
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, *inv)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Name() < invoices[j].Name() })
+	return invoices, nil
+}
+
+// invoicePath returns the path of the invoice file for the given ID. The directory is named after
+// the SHA256 of the ID so it is always a valid file name
+func (f *FileProvider) invoicePath(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(f.Root, invoiceDirectory, hex.EncodeToString(sum[:]), invoiceFile)
+}
+
+// parcelPath returns the path of the parcel data, or an error wrapping ErrInvalidSHA if the SHA is
+// not valid
+func (f *FileProvider) parcelPath(sha string) (string, error) {
+	if !fsutil.ValidSHA256(sha) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSHA, sha)
+	}
+	return filepath.Join(f.Root, parcelDirectory, sha, parcelFile), nil
+}
+
+func (f *FileProvider) readInvoice(path string, id string) (*types.Invoice, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("invoice %s %w", id, ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read invoice %s: %w", id, err)
+	}
+
+	var inv types.Invoice
+	if err := toml.Unmarshal(raw, &inv); err != nil {
+		return nil, fmt.Errorf("unable to parse invoice %s: %w", id, err)
+	}
+	return &inv, nil
+}
+
+func (f *FileProvider) writeInvoice(path string, inv *types.Invoice) error {
+	raw, err := inv.MarshalCanonical()
+	if err != nil {
+		return fmt.Errorf("unable to encode invoice: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(path, raw, 0644); err != nil {
+		return fmt.Errorf("unable to write invoice: %w", err)
+	}
+	return nil
+}
+
+func (f *FileProvider) writeLabel(path string, label *types.Label) error {
+	raw, err := toml.Marshal(*label)
+	if err != nil {
+		return fmt.Errorf("unable to encode label: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(path, raw, 0644); err != nil {
+		return fmt.Errorf("unable to write label: %w", err)
+	}
+	return nil
+}
+
+func (f *FileProvider) missingLabels(inv *types.Invoice) []types.Label {
+	var missing []types.Label
+	for _, p := range inv.Parcel {
+		path, err := f.parcelPath(p.Label.SHA256)
+		if err != nil {
+			missing = append(missing, p.Label)
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			missing = append(missing, p.Label)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/deislabs/go-bindle/types"
+)
+
+type memoryInvoice struct {
+	invoice types.Invoice
+	yanked  bool
+}
+
+// MemoryProvider is a Provider that keeps everything in memory. It is mostly useful for tests and
+// ephemeral servers
+type MemoryProvider struct {
+	lock     sync.RWMutex
+	invoices map[string]*memoryInvoice
+	parcels  map[string][]byte
+}
+
+// NewMemoryProvider returns an empty MemoryProvider
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		invoices: map[string]*memoryInvoice{},
+		parcels:  map[string][]byte{},
+	}
+}
+
+// CreateInvoice implements Provider
+func (m *MemoryProvider) CreateInvoice(inv *types.Invoice) ([]types.Label, error) {
+	if err := validateLabels(inv); err != nil {
+		return nil, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.invoices[inv.Name()]; exists {
+		return nil, fmt.Errorf("invoice %s %w", inv.Name(), ErrAlreadyExists)
+	}
+	m.invoices[inv.Name()] = &memoryInvoice{invoice: *inv}
+	return m.missingLabels(inv), nil
+}
+
+// GetInvoice implements Provider
+func (m *MemoryProvider) GetInvoice(id string) (*types.Invoice, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	stored, exists := m.invoices[id]
+	if !exists {
+		return nil, fmt.Errorf("invoice %s %w", id, ErrNotFound)
+	}
+	if stored.yanked {
+		return nil, fmt.Errorf("%s: %w", id, ErrYanked)
+	}
+	inv := stored.invoice
+	return &inv, nil
+}
+
+// GetYankedInvoice implements Provider
+func (m *MemoryProvider) GetYankedInvoice(id string) (*types.Invoice, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	stored, exists := m.invoices[id]
+	if !exists {
+		return nil, fmt.Errorf("invoice %s %w", id, ErrNotFound)
+	}
+	inv := stored.invoice
+	return &inv, nil
+}
+
+// YankInvoice implements Provider
+func (m *MemoryProvider) YankInvoice(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored, exists := m.invoices[id]
+	if !exists {
+		return fmt.Errorf("invoice %s %w", id, ErrNotFound)
+	}
+	yanked := true
+	stored.yanked = true
+	stored.invoice.Yanked = &yanked
+	return nil
+}
+
+// CreateParcel implements Provider
+func (m *MemoryProvider) CreateParcel(bindleID string, sha string, data io.Reader) error {
+	label, err := findParcelLabel(m, bindleID, sha)
+	if err != nil {
+		return err
+	}
+
+	v := newVerifier()
+	raw, err := ioutil.ReadAll(io.TeeReader(limitParcel(data, label), v))
+	if err != nil {
+		return fmt.Errorf("unable to read parcel data: %w", err)
+	}
+	if err := v.verify(label); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.parcels[sha]; exists {
+		return fmt.Errorf("parcel %s %w", sha, ErrAlreadyExists)
+	}
+	m.parcels[sha] = raw
+	return nil
+}
+
+// GetParcel implements Provider. The returned reader also implements `io.Seeker`
+func (m *MemoryProvider) GetParcel(bindleID string, sha string) (io.ReadCloser, error) {
+	if _, err := findParcelLabel(m, bindleID, sha); err != nil {
+		return nil, err
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	raw, exists := m.parcels[sha]
+	if !exists {
+		return nil, fmt.Errorf("parcel %s %w", sha, ErrNotFound)
+	}
+	return nopCloser{bytes.NewReader(raw)}, nil
+}
+
+// MissingParcels implements Provider
+func (m *MemoryProvider) MissingParcels(bindleID string) ([]types.Label, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	stored, exists := m.invoices[bindleID]
+	if !exists {
+		return nil, fmt.Errorf("invoice %s %w", bindleID, ErrNotFound)
+	}
+	return m.missingLabels(&stored.invoice), nil
+}
+
+// ListInvoices implements Lister
+func (m *MemoryProvider) ListInvoices() ([]types.Invoice, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	invoices := make([]types.Invoice, 0, len(m.invoices))
+	for _, stored := range m.invoices {
+		invoices = append(invoices, stored.invoice)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Name() < invoices[j].Name() })
+	return invoices, nil
+}
+
+// missingLabels returns the labels of all parcels in the invoice that haven't been stored. The
+// caller must hold the lock
+func (m *MemoryProvider) missingLabels(inv *types.Invoice) []types.Label {
+	var missing []types.Label
+	for _, p := range inv.Parcel {
+		if _, exists := m.parcels[p.Label.SHA256]; !exists {
+			missing = append(missing, p.Label)
+		}
+	}
+	return missing
+}
+
+// nopCloser is like `ioutil.NopCloser` but keeps the `io.Seeker` implementation of the reader
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,128 @@
+// Package storage defines where invoices and parcels live, independent of how they are served.
+// The same `Provider` can back a local cache, a test server or an embedded Bindle server.
+// Filesystem and in-memory implementations are included
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/deislabs/go-bindle/internal/fsutil"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// ErrNotFound is returned when an invoice or parcel doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrYanked is returned when fetching an invoice that has been yanked without asking for yanked
+// invoices
+var ErrYanked = errors.New("invoice is yanked")
+
+// ErrAlreadyExists is returned when creating an invoice or parcel that already exists
+var ErrAlreadyExists = errors.New("already exists")
+
+// ErrInvalidParcel is returned when parcel data doesn't match the SHA or size from its label
+var ErrInvalidParcel = errors.New("parcel data does not match its label")
+
+// ErrInvalidSHA is returned when a parcel SHA is not a SHA256 encoded as 64 lowercase hex
+// characters. Invoices with such parcels are refused, as parcel SHAs are used as file names
+var ErrInvalidSHA = errors.New("invalid parcel SHA256")
+
+// Provider stores invoices and parcels. Implementations must be safe for concurrent use and should
+// return (possibly wrapped) errors defined in this package so callers can handle them
+type Provider interface {
+	// CreateInvoice stores a new invoice, returning the labels of any of its parcels that haven't
+	// been stored yet. Invoices with a parcel whose SHA is not valid are refused with ErrInvalidSHA
+	CreateInvoice(inv *types.Invoice) ([]types.Label, error)
+	// GetInvoice returns the invoice with the given ID, or ErrYanked if it has been yanked
+	GetInvoice(id string) (*types.Invoice, error)
+	// GetYankedInvoice returns the invoice with the given ID, whether or not it has been yanked
+	GetYankedInvoice(id string) (*types.Invoice, error)
+	// YankInvoice marks the invoice with the given ID as yanked
+	YankInvoice(id string) error
+	// CreateParcel stores the data for a parcel of the given invoice, verifying it against the
+	// parcel's label. Data larger than the label's size must be refused with ErrInvalidParcel
+	// without reading all of it
+	CreateParcel(bindleID string, sha string, data io.Reader) error
+	// GetParcel returns the data of a parcel of the given invoice. The caller must close the reader
+	GetParcel(bindleID string, sha string) (io.ReadCloser, error)
+	// MissingParcels returns the labels of all parcels of the given invoice that haven't been
+	// stored yet
+	MissingParcels(bindleID string) ([]types.Label, error)
+}
+
+// Lister is implemented by providers that can list every invoice they store, including yanked
+// ones. It is used to build search indexes for persistent providers
+type Lister interface {
+	ListInvoices() ([]types.Invoice, error)
+}
+
+// FindLabel returns the label of the parcel with the given SHA in the invoice, or nil if the
+// invoice doesn't contain it
+func FindLabel(inv *types.Invoice, sha string) *types.Label {
+	for i := range inv.Parcel {
+		if inv.Parcel[i].Label.SHA256 == sha {
+			return &inv.Parcel[i].Label
+		}
+	}
+	return nil
+}
+
+// validateLabels checks that the SHAs of all parcels of the invoice are valid
+func validateLabels(inv *types.Invoice) error {
+	for _, p := range inv.Parcel {
+		if !fsutil.ValidSHA256(p.Label.SHA256) {
+			return fmt.Errorf("parcel %s of invoice %s: %w: %q", p.Label.Name, inv.Name(), ErrInvalidSHA, p.Label.SHA256)
+		}
+	}
+	return nil
+}
+
+// findParcelLabel looks up the invoice with the given ID in the provider and returns the label of
+// the given parcel in it
+func findParcelLabel(p Provider, bindleID string, sha string) (*types.Label, error) {
+	inv, err := p.GetYankedInvoice(bindleID)
+	if err != nil {
+		return nil, err
+	}
+	label := FindLabel(inv, sha)
+	if label == nil {
+		return nil, fmt.Errorf("parcel %s in invoice %s %w", sha, bindleID, ErrNotFound)
+	}
+	return label, nil
+}
+
+// limitParcel limits parcel data to one byte more than the size in its label. That is enough for
+// the verifier to notice oversized data without reading or storing all of it
+func limitParcel(data io.Reader, label *types.Label) io.Reader {
+	return io.LimitReader(data, int64(label.Size)+1)
+}
+
+// verifier hashes and counts data written to it so it can be checked against a label
+type verifier struct {
+	hash hash.Hash
+	size uint64
+}
+
+func newVerifier() *verifier {
+	return &verifier{hash: sha256.New()}
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	v.size += uint64(len(p))
+	return v.hash.Write(p)
+}
+
+func (v *verifier) verify(label *types.Label) error {
+	if hex.EncodeToString(v.hash.Sum(nil)) != label.SHA256 {
+		return fmt.Errorf("SHA256 of %s: %w", label.Name, ErrInvalidParcel)
+	}
+	if v.size != label.Size {
+		return fmt.Errorf("size of %s: %w", label.Name, ErrInvalidParcel)
+	}
+	return nil
+}
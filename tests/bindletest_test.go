@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deislabs/go-bindle/bindletest"
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/types"
+)
+
+func newFakeServer(t *testing.T, useTLS bool) *bindletest.Server {
+	t.Helper()
+	newServer := bindletest.NewServer
+	if useTLS {
+		newServer = bindletest.NewTLSServer
+	}
+	server, err := newServer()
+	if err != nil {
+		t.Fatalf("Unable to start fake bindle server: %s", err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newFakeClient(t *testing.T, server *bindletest.Server, opts ...client.Option) *client.Client {
+	t.Helper()
+	bindleClient, err := server.Client(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bindleClient
+}
+
+func TestFakeServer(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		server := newFakeServer(t, useTLS)
+		bindleClient := newFakeClient(t, server, client.WithHostVerification(nil))
+
+		inv := load_scaffold_invoice(t, "valid_v2")
+		resp, err := bindleClient.CreateInvoice(inv)
+		if err != nil {
+			t.Fatalf("Unable to create invoice: %s", err)
+		}
+		if len(resp.Missing) != 2 {
+			t.Fatalf("Expected 2 missing parcels, got %d", len(resp.Missing))
+		}
+
+		data := load_scaffold_parcel_data(t, "valid_v2", "other")
+		if err := bindleClient.CreateParcel(inv.Name(), inv.Parcel[0].Label.SHA256, data); err != nil {
+			t.Fatalf("Unable to create parcel: %s", err)
+		}
+
+		missing, err := bindleClient.GetMissingParcels(inv.Name())
+		if err != nil {
+			t.Fatalf("Unable to get missing parcels: %s", err)
+		}
+		if len(missing.Missing) != 1 || missing.Missing[0].SHA256 != inv.Parcel[1].Label.SHA256 {
+			t.Fatalf("Expected only the second parcel to be missing, got %v", missing.Missing)
+		}
+
+		serverData, err := bindleClient.GetParcel(inv.Name(), inv.Parcel[0].Label.SHA256)
+		if err != nil {
+			t.Fatalf("Unable to fetch parcel from server: %s", err)
+		}
+		if !reflect.DeepEqual(data, serverData) {
+			t.Fatalf("Did not get back valid data from the server\nExpected: %s\nGot: %s", data, serverData)
+		}
+
+		// The host signature is checked since host verification is enabled
+		fetched, err := bindleClient.GetInvoice(inv.Name())
+		if err != nil {
+			t.Fatalf("Unable to get invoice: %s", err)
+		}
+		if len(fetched.Signature) != 1 || fetched.Signature[0].Role != types.RoleHost {
+			t.Fatalf("Expected the invoice to have a host signature, got %v", fetched.Signature)
+		}
+
+		query := "enterprise.com"
+		matches, err := bindleClient.QueryInvoices(types.QueryOptions{Query: &query})
+		if err != nil {
+			t.Fatalf("Unable to query invoices: %s", err)
+		}
+		if matches.Total != 1 || len(matches.Invoices) != 1 {
+			t.Fatalf("Expected 1 match, got %d", matches.Total)
+		}
+
+		if err := bindleClient.YankInvoice(inv.Name()); err != nil {
+			t.Fatalf("Unable to yank invoice: %s", err)
+		}
+		if _, err := bindleClient.GetInvoice(inv.Name()); err == nil {
+			t.Fatal("Shouldn't be able to get a yanked invoice")
+		}
+		if _, err := bindleClient.GetYankedInvoice(inv.Name()); err != nil {
+			t.Fatalf("Should be able to get a yanked invoice: %s", err)
+		}
+
+		hostKeys, err := bindleClient.GetHostKeys(context.Background(), types.RoleHost)
+		if err != nil {
+			t.Fatalf("Unable to get host keys: %s", err)
+		}
+		if len(hostKeys.Key) != 1 || hostKeys.Key[0].Key != server.HostKey.Key {
+			t.Fatalf("Unexpected host keys: %v", hostKeys.Key)
+		}
+	}
+}
+
+func TestHostVerificationFailure(t *testing.T) {
+	server := newFakeServer(t, false)
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := newFakeClient(t, server).CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	// Trust a different server's key instead of the one that signed the invoice
+	other := newFakeServer(t, false)
+	bindleClient := newFakeClient(t, server, client.WithHostVerification(other.HostKeyring()))
+	if _, err := bindleClient.GetInvoice(inv.Name()); !errors.Is(err, types.ErrMissingSignatureKey) {
+		t.Fatalf("Expected host verification to fail, got: %v", err)
+	}
+}
+
+func TestFakeServerFaults(t *testing.T) {
+	server := newFakeServer(t, false)
+	bindleClient := newFakeClient(t, server)
+
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := bindleClient.CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetFaults(bindletest.Faults{ErrorStatus: http.StatusServiceUnavailable, ErrorCount: 1})
+	if _, err := bindleClient.GetInvoice(inv.Name()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("Expected a 503 error, got: %v", err)
+	}
+	if _, err := bindleClient.GetInvoice(inv.Name()); err != nil {
+		t.Fatalf("Only the first request should fail: %s", err)
+	}
+
+	server.SetFaults(bindletest.Faults{TruncateBodies: true})
+	if _, err := bindleClient.GetInvoice(inv.Name()); err == nil {
+		t.Fatal("Expected an error for a truncated body")
+	}
+
+	server.SetFaults(bindletest.Faults{Latency: 100 * time.Millisecond})
+	start := time.Now()
+	if _, err := bindleClient.GetInvoice(inv.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("Expected the response to be delayed")
+	}
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deislabs/go-bindle/bindletest"
+	"github.com/deislabs/go-bindle/cache"
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/types"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParcelCache(t *testing.T) {
+	parcelCache, err := cache.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("some parcel data")
+	sha := sha256Hex(data)
+	if _, err := parcelCache.GetParcel(sha); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("Expected a cache miss, got: %v", err)
+	}
+	if err := parcelCache.PutParcel(sha, bytes.NewReader([]byte("other data"))); !errors.Is(err, cache.ErrCorrupt) {
+		t.Fatalf("Expected data not matching the SHA to be rejected, got: %v", err)
+	}
+	if err := parcelCache.PutParcel(sha, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Unable to cache parcel: %s", err)
+	}
+
+	reader, err := parcelCache.GetParcel(sha)
+	if err != nil {
+		t.Fatalf("Unable to get cached parcel: %s", err)
+	}
+	cached, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, cached) {
+		t.Fatalf("Got back different data\nExpected: %s\nGot: %s", data, cached)
+	}
+
+	// Corrupt the cached file and make sure it is detected and dropped
+	if err := ioutil.WriteFile(filepath.Join(parcelCache.Dir, "parcels", sha), []byte("corrupted!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err = parcelCache.GetParcel(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if !errors.Is(err, cache.ErrCorrupt) {
+		t.Fatalf("Expected corruption to be detected, got: %v", err)
+	}
+	if parcelCache.HasParcel(sha) {
+		t.Fatal("Corrupt parcel should have been removed from the cache")
+	}
+}
+
+func TestParcelCacheInvalidSHA(t *testing.T) {
+	parcelCache, err := cache.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SHAs are used as file names, so anything that could point somewhere else must be refused
+	valid := sha256Hex([]byte("some parcel data"))
+	for _, sha := range []string{"", ".", "..", "../invoices", strings.ToUpper(valid), valid[:63], valid + "0"} {
+		if parcelCache.HasParcel(sha) {
+			t.Errorf("Expected no cached parcel for invalid SHA %q", sha)
+		}
+		if _, err := parcelCache.GetParcel(sha); !errors.Is(err, cache.ErrInvalidSHA) {
+			t.Errorf("Expected invalid SHA error for %q, got: %v", sha, err)
+		}
+		if err := parcelCache.PutParcel(sha, bytes.NewReader([]byte("some parcel data"))); !errors.Is(err, cache.ErrInvalidSHA) {
+			t.Errorf("Expected invalid SHA error for %q, got: %v", sha, err)
+		}
+	}
+}
+
+func TestParcelCacheEviction(t *testing.T) {
+	parcelCache, err := cache.New(t.TempDir(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := []byte("first parcel")
+	second := []byte("second parcel")
+	past := time.Now().Add(-time.Hour)
+	if err := parcelCache.PutParcel(sha256Hex(first), bytes.NewReader(first)); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(parcelCache.Dir, "parcels", sha256Hex(first)), past, past)
+	if err := parcelCache.PutParcel(sha256Hex(second), bytes.NewReader(second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one parcel fits and the first one is the least recently used
+	if parcelCache.HasParcel(sha256Hex(first)) {
+		t.Fatal("Least recently used parcel should have been evicted")
+	}
+	if !parcelCache.HasParcel(sha256Hex(second)) {
+		t.Fatal("Most recently used parcel should still be cached")
+	}
+	size, err := parcelCache.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size > parcelCache.MaxSize {
+		t.Fatalf("Cache size %d is over the limit", size)
+	}
+
+	// Reading a parcel makes it the most recently used
+	parcelCache.MaxSize = 0
+	if err := parcelCache.PutParcel(sha256Hex(first), bytes.NewReader(first)); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(parcelCache.Dir, "parcels", sha256Hex(second)), past, past)
+	os.Chtimes(filepath.Join(parcelCache.Dir, "parcels", sha256Hex(first)), past.Add(time.Minute), past.Add(time.Minute))
+	reader, err := parcelCache.GetParcel(sha256Hex(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+
+	parcelCache.MaxSize = 20
+	if err := parcelCache.Evict(); err != nil {
+		t.Fatal(err)
+	}
+	if !parcelCache.HasParcel(sha256Hex(second)) || parcelCache.HasParcel(sha256Hex(first)) {
+		t.Fatal("Expected the parcel that wasn't read to be evicted")
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	server := newFakeServer(t, false)
+	parcelCache, err := cache.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindleClient := newFakeClient(t, server, client.WithCache(parcelCache))
+
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := bindleClient.CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+	data := load_scaffold_parcel_data(t, "valid_v1", "parcel")
+	sha := inv.Parcel[0].Label.SHA256
+	if err := bindleClient.CreateParcel(inv.Name(), sha, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bindleClient.GetInvoice(inv.Name()); err != nil {
+		t.Fatalf("Unable to get invoice: %s", err)
+	}
+	reader, err := bindleClient.GetParcelReader(inv.Name(), sha)
+	if err != nil {
+		t.Fatalf("Unable to get parcel: %s", err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+	if !parcelCache.HasParcel(sha) {
+		t.Fatal("Parcel should have been cached after reading it")
+	}
+
+	// Everything is now served from the cache, even if the server is failing
+	server.SetFaults(bindletest.Faults{ErrorStatus: http.StatusInternalServerError})
+	if _, err := bindleClient.GetInvoice(inv.Name()); err != nil {
+		t.Fatalf("Invoice should have been served from the cache: %s", err)
+	}
+	cached, err := bindleClient.GetParcel(inv.Name(), sha)
+	if err != nil {
+		t.Fatalf("Parcel should have been served from the cache: %s", err)
+	}
+	if !bytes.Equal(data, cached) {
+		t.Fatalf("Got back different data\nExpected: %s\nGot: %s", data, cached)
+	}
+}
+
+func TestClientCacheHostVerification(t *testing.T) {
+	server := newFakeServer(t, false)
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := newFakeClient(t, server).CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+	parcelCache, err := cache.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindleClient := newFakeClient(t, server, client.WithCache(parcelCache), client.WithHostVerification(server.HostKeyring()))
+
+	// The cache may be shared with clients that don't verify host signatures, so a copy without a
+	// host signature or signed by another host must not be trusted
+	if err := parcelCache.PutInvoice(&inv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bindleClient.GetInvoice(inv.Name()); !errors.Is(err, types.ErrMissingHostSignature) {
+		t.Fatalf("Expected a cached invoice without a host signature to be refused, got: %v", err)
+	}
+	if _, err := bindleClient.GetYankedInvoice(inv.Name()); !errors.Is(err, types.ErrMissingHostSignature) {
+		t.Fatalf("Expected a cached invoice without a host signature to be refused, got: %v", err)
+	}
+
+	other := newFakeServer(t, false)
+	otherClient := newFakeClient(t, other)
+	if _, err := otherClient.CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+	otherSigned, err := otherClient.GetInvoice(inv.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parcelCache.PutInvoice(otherSigned); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bindleClient.GetInvoice(inv.Name()); !errors.Is(err, types.ErrMissingSignatureKey) {
+		t.Fatalf("Expected a cached invoice signed by another host to be refused, got: %v", err)
+	}
+
+	// A cached invoice signed by the trusted host is still served from the cache
+	signed, err := newFakeClient(t, server).GetInvoice(inv.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parcelCache.PutInvoice(signed); err != nil {
+		t.Fatal(err)
+	}
+	server.SetFaults(bindletest.Faults{ErrorStatus: http.StatusInternalServerError})
+	if _, err := bindleClient.GetInvoice(inv.Name()); err != nil {
+		t.Fatalf("Invoice signed by the trusted host should have been served from the cache: %s", err)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestCanonicalInvoice(t *testing.T) {
+	for _, name := range []string{"valid_v1", "valid_v2", "lotsa_parcels", "incomplete"} {
+		inv := load_scaffold_invoice(t, name)
+
+		canonical, err := inv.MarshalCanonical()
+		if err != nil {
+			t.Fatalf("Unable to encode %s invoice: %s", name, err)
+		}
+
+		var decoded types.Invoice
+		if err := toml.NewDecoder(bytes.NewReader(canonical)).Strict(true).Decode(&decoded); err != nil {
+			t.Fatalf("Unable to decode canonical %s invoice: %s\n%s", name, err, canonical)
+		}
+		if !reflect.DeepEqual(inv, decoded) {
+			t.Fatalf("Canonical %s invoice does not round trip\nExpected: %+v\nGot: %+v", name, inv, decoded)
+		}
+
+		again, err := decoded.MarshalCanonical()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(canonical, again) {
+			t.Fatalf("Canonical encoding of %s is not stable\nFirst:\n%s\nSecond:\n%s", name, canonical, again)
+		}
+	}
+}
+
+func TestCanonicalDigest(t *testing.T) {
+	inv := newTestInvoice(testAuthor)
+	inv.Annotations = map[string]string{}
+	// Add enough keys that map ordering would almost certainly differ between runs
+	for _, k := range []string{"zeta", "alpha", "with space", "mu", "beta", "omega", "gamma"} {
+		inv.Annotations[k] = "line one\nline two\t\"quoted\" \\ \u0001"
+	}
+
+	first, err := inv.CanonicalDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		digest, err := inv.CanonicalDigest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != first {
+			t.Fatal("Canonical digest should be stable")
+		}
+	}
+
+	canonical, err := inv.MarshalCanonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded types.Invoice
+	if err := toml.NewDecoder(bytes.NewReader(canonical)).Strict(true).Decode(&decoded); err != nil {
+		t.Fatalf("Unable to decode canonical invoice: %s\n%s", err, canonical)
+	}
+	if !reflect.DeepEqual(inv.Annotations, decoded.Annotations) {
+		t.Fatalf("Annotations do not round trip\nExpected: %q\nGot: %q", inv.Annotations, decoded.Annotations)
+	}
+
+	inv.Bindle.Version = "0.2.0"
+	if digest, _ := inv.CanonicalDigest(); digest == first {
+		t.Fatal("Changing the invoice should change the digest")
+	}
+}
@@ -16,7 +16,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"reflect"
 	"testing"
 	"time"
 
@@ -219,126 +218,32 @@ func load_scaffold_parcel_data(t *testing.T, invoiceName string, parcelName stri
 
 func TestSuccessful(t *testing.T) {
 	controller := newTestController(t)
-
-	// First try creating an invoice
-	inv := load_scaffold_invoice(t, "valid_v1")
-	_, err := controller.Client.CreateInvoice(inv)
-	if err != nil {
-		t.Fatalf("Unable to create invoice: %s", err)
-	}
-
-	// Now create the parcel associated with that invoice
-	data := load_scaffold_parcel_data(t, "valid_v1", "parcel")
-	if err := controller.Client.CreateParcel(inv.Name(), inv.Parcel[0].Label.SHA256, data); err != nil {
-		t.Fatalf("Unable to create parcel: %s", err)
-	}
-
-	// Now see if we get the parcel back from the server
-	serverData, err := controller.Client.GetParcel(inv.Name(), inv.Parcel[0].Label.SHA256)
-	if err != nil {
-		t.Fatalf("Unable to fetch parcel from server: %s", err)
-	}
-
-	if !reflect.DeepEqual(data, serverData) {
-		t.Fatalf("Did not get back valid data from the server\nExpected: %s\nGot: %s", data, serverData)
-	}
-
-	// Now try yanking the parcel and fetching it to make sure it gives us an error
-	if err := controller.Client.YankInvoice(inv.Name()); err != nil {
-		t.Fatalf("Unable to yank invoice: %s", err)
-	}
-
-	_, err = controller.Client.GetInvoice(inv.Name())
-	if err == nil {
-		t.Fatal("Shouldn't be able to get a yanked invoice")
-	}
-
-	// Get the yanked invoice and make sure it works
-	_, err = controller.Client.GetYankedInvoice(inv.Name())
-	if err != nil {
-		t.Fatalf("Should be able to get a yanked invoice: %s", err)
-	}
+	conformanceSuccessful(t, &controller.Client)
 }
 
 func TestStreamingSuccessful(t *testing.T) {
 	controller := newTestController(t)
-
-	// First try creating an invoice
-	resp, err := controller.Client.CreateInvoiceFromFile(scaffold_invoice_path("valid_v1"))
-	if err != nil {
-		t.Fatalf("Unable to create invoice: %s", err)
-	}
-
-	inv := resp.Invoice
-
-	// Now create the parcel associated with that invoice
-	data := load_scaffold_parcel_data(t, "valid_v1", "parcel")
-	if err := controller.Client.CreateParcelFromFile(inv.Name(), inv.Parcel[0].Label.SHA256, scaffold_parcel_path("valid_v1", "parcel")); err != nil {
-		t.Fatalf("Unable to create parcel: %s", err)
-	}
-
-	// Now see if we get the parcel back from the server
-	serverData, err := controller.Client.GetParcel(inv.Name(), inv.Parcel[0].Label.SHA256)
-	if err != nil {
-		t.Fatalf("Unable to fetch parcel from server: %s", err)
-	}
-
-	if !reflect.DeepEqual(data, serverData) {
-		t.Fatalf("Did not get back valid data from the server\nExpected: %s\nGot: %s", data, serverData)
-	}
+	conformanceStreamingSuccessful(t, &controller.Client)
 }
 
 func TestAlreadyCreated(t *testing.T) {
 	controller := newTestController(t)
-
-	// Create an invoice with two parcels
-	inv := load_scaffold_invoice(t, "valid_v2")
-	_, err := controller.Client.CreateInvoice(inv)
-	if err != nil {
-		t.Fatalf("Unable to create invoice: %s", err)
-	}
-
-	// Now create the parcels associated with that invoice
-	data := load_scaffold_parcel_data(t, "valid_v2", "other")
-	if err := controller.Client.CreateParcel(inv.Name(), inv.Parcel[0].Label.SHA256, data); err != nil {
-		t.Fatalf("Unable to create parcel: %s", err)
-	}
-
-	data = load_scaffold_parcel_data(t, "valid_v2", "parcel")
-	if err := controller.Client.CreateParcel(inv.Name(), inv.Parcel[1].Label.SHA256, data); err != nil {
-		t.Fatalf("Unable to create parcel: %s", err)
-	}
-
-	// Now create another invoice that already has all parcels existing
-	inv = load_scaffold_invoice(t, "valid_v1")
-	resp, err := controller.Client.CreateInvoice(inv)
-	if err != nil {
-		t.Fatalf("Unable to create invoice: %s", err)
-	}
-
-	if resp.Missing != nil {
-		t.Fatalf("Should have no missing parcels")
-	}
+	conformanceAlreadyCreated(t, &controller.Client)
 }
 
 func TestMissing(t *testing.T) {
 	controller := newTestController(t)
+	conformanceMissing(t, &controller.Client)
+}
 
-	// Create an invoice with two parcels
-	inv := load_scaffold_invoice(t, "valid_v2")
-	_, err := controller.Client.CreateInvoice(inv)
-	if err != nil {
-		t.Fatalf("Unable to create invoice: %s", err)
-	}
-
-	missing, err := controller.Client.GetMissingParcels(inv.Name())
-	if err != nil {
-		t.Fatalf("Should have been able to get missing parcels: %s", err)
-	}
+func TestQuery(t *testing.T) {
+	controller := newTestController(t)
+	conformanceQuery(t, &controller.Client)
+}
 
-	if len(missing.Missing) != len(inv.Parcel) {
-		t.Fatalf("Expected to get %d missing parcels, got %d", len(inv.Parcel), len(missing.Missing))
-	}
+func TestHostSignature(t *testing.T) {
+	controller := newTestController(t)
+	conformanceHostSignature(t, &controller.Client)
 }
 
 func TestSignVerify(t *testing.T) {
@@ -462,3 +367,56 @@ func TestSignVerifyMissingKey(t *testing.T) {
 		return
 	}
 }
+
+func TestVerifyHostSignature(t *testing.T) {
+	creatorKey, creatorPriv, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKey, hostPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice := &types.Invoice{
+		BindleVersion: "1.0.0",
+		Bindle: types.BindleSpec{
+			Name:    "importantproj",
+			Version: "0.1.0",
+			Authors: []string{
+				testAuthor,
+				testAuthor2,
+			},
+		},
+		Parcel: []types.Parcel{
+			types.NewParcel("importantfile", "application/important", []byte("something very important")),
+		},
+	}
+
+	if err := invoice.GenerateSignature(testAuthor, types.RoleCreator, creatorKey, creatorPriv); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invoice.VerifyHostSignature([]types.SignatureKey{*hostKey}); !errors.Is(err, types.ErrMissingHostSignature) {
+		t.Fatalf("Expected missing host signature error, got: %v", err)
+	}
+
+	if err := invoice.GenerateSignature(testAuthor2, types.RoleHost, hostKey, hostPriv); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invoice.VerifyHostSignature([]types.SignatureKey{*hostKey}); err != nil {
+		t.Fatalf("Host signature should be valid: %s", err)
+	}
+
+	// a different host key with the same label should not validate the signature
+	otherHostKey, _, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invoice.VerifyHostSignature([]types.SignatureKey{*otherHostKey}); !errors.Is(err, types.ErrMissingSignatureKey) {
+		t.Fatalf("Expected missing signature key error, got: %v", err)
+	}
+}
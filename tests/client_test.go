@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestWireFormat(t *testing.T) {
+	inv := load_scaffold_invoice(t, "valid_v1")
+
+	// A server that answers in whatever format the client asked for, or TOML for the "/toml" paths
+	var gotAccept, gotContentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		if r.Method == http.MethodPost {
+			gotContentType = r.Header.Get("Content-Type")
+			var posted types.Invoice
+			if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(types.InvoiceCreateResponse{Invoice: posted})
+			return
+		}
+
+		if gotAccept == "application/json" && r.URL.Path != "/v1/_i/toml/1.0.0" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(inv)
+			return
+		}
+		w.Header().Set("Content-Type", "application/toml")
+		toml.NewEncoder(w).Encode(inv)
+	}))
+	t.Cleanup(server.Close)
+
+	bindleClient, err := client.New(server.URL+"/v1", nil, client.WithWireFormat(client.FormatJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := bindleClient.GetInvoice(inv.Name())
+	if err != nil {
+		t.Fatalf("Unable to get invoice as JSON: %s", err)
+	}
+	if gotAccept != "application/json" {
+		t.Errorf("Expected JSON to be requested, got Accept: %s", gotAccept)
+	}
+	if !reflect.DeepEqual(inv, *fetched) {
+		t.Fatalf("Invoice does not match\nExpected: %+v\nGot: %+v", inv, fetched)
+	}
+
+	// Servers that ignore the Accept header should still work
+	fetched, err = bindleClient.GetInvoice("toml/1.0.0")
+	if err != nil {
+		t.Fatalf("Unable to get TOML invoice: %s", err)
+	}
+	if !reflect.DeepEqual(inv, *fetched) {
+		t.Fatalf("Invoice does not match\nExpected: %+v\nGot: %+v", inv, fetched)
+	}
+
+	resp, err := bindleClient.CreateInvoice(inv)
+	if err != nil {
+		t.Fatalf("Unable to create invoice as JSON: %s", err)
+	}
+	if gotContentType != "application/json" {
+		t.Errorf("Expected a JSON request body, got Content-Type: %s", gotContentType)
+	}
+	if !reflect.DeepEqual(inv, resp.Invoice) {
+		t.Fatalf("Invoice does not match\nExpected: %+v\nGot: %+v", inv, resp.Invoice)
+	}
+}
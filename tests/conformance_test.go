@@ -0,0 +1,303 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/keyring"
+	"github.com/deislabs/go-bindle/server"
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// conformanceTests are run against every Bindle server implementation: bindle-server in the
+// integration tests and the native Go server in `TestNativeServerConformance`
+var conformanceTests = map[string]func(t *testing.T, bindleClient *client.Client){
+	"Successful":          conformanceSuccessful,
+	"StreamingSuccessful": conformanceStreamingSuccessful,
+	"AlreadyCreated":      conformanceAlreadyCreated,
+	"Missing":             conformanceMissing,
+	"Query":               conformanceQuery,
+	"HostSignature":       conformanceHostSignature,
+}
+
+func TestNativeServerConformance(t *testing.T) {
+	for name, test := range conformanceTests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, newNativeServerClient(t))
+		})
+	}
+}
+
+func TestNativeServerRequestLimits(t *testing.T) {
+	bindleServer := server.New(storage.NewMemoryProvider(), server.WithMaxRequestSize(2048))
+	httpServer := httptest.NewServer(http.StripPrefix("/v1", bindleServer))
+	t.Cleanup(httpServer.Close)
+	bindleClient, err := client.New(httpServer.URL+"/v1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bindleClient.CreateInvoice(*newTestInvoice(testAuthor)); err != nil {
+		t.Fatalf("Unable to create an invoice within the size limit: %s", err)
+	}
+
+	valid := "bindleVersion = \"1.0.0\"\n[bindle]\nname = \"example.com/limits\"\nversion = \"1.0.0\"\n"
+	for _, test := range []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"TooLarge", "application/toml", valid + "description = \"" + strings.Repeat("a", 4096) + "\"\n", http.StatusRequestEntityTooLarge},
+		{"UnknownTOMLField", "application/toml", "unknown = true\n" + valid, http.StatusBadRequest},
+		{"UnknownJSONField", "application/json", `{"bindleVersion": "1.0.0", "bindle": {"name": "example.com/limits", "version": "1.0.0"}, "unknown": true}`, http.StatusBadRequest},
+	} {
+		resp, err := bindleClient.RawRequest("/_i", http.MethodPost, ioutil.NopCloser(strings.NewReader(test.body)), test.contentType)
+		if err != nil {
+			t.Fatalf("%s: request failed: %s", test.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestNativeServerParcelLimits(t *testing.T) {
+	bindleServer := server.New(storage.NewMemoryProvider())
+	httpServer := httptest.NewServer(http.StripPrefix("/v1", bindleServer))
+	t.Cleanup(httpServer.Close)
+	bindleClient, err := client.New(httpServer.URL+"/v1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := bindleClient.CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+	sha := inv.Parcel[0].Label.SHA256
+	data := load_scaffold_parcel_data(t, "valid_v1", "parcel")
+	oversized := append(append([]byte{}, data...), bytes.Repeat([]byte("a"), 4096)...)
+
+	// Uploads declaring a larger size than the label are refused up front
+	resp, err := http.Post(httpServer.URL+"/v1/_i/"+inv.Name()+"@"+sha, "application/octet-stream", bytes.NewReader(oversized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status %d for an oversized parcel, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+
+	// Streamed uploads without a declared size are cut off and refused as invalid
+	err = bindleClient.CreateParcelFromReader(inv.Name(), sha, ioutil.NopCloser(bytes.NewReader(oversized)))
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("Expected an oversized streamed parcel to be refused with a 400, got: %v", err)
+	}
+
+	if err := bindleClient.CreateParcel(inv.Name(), sha, data); err != nil {
+		t.Fatalf("A parcel matching its label should be accepted: %s", err)
+	}
+}
+
+// newNativeServerClient starts a native server with in-memory storage and a fresh host key,
+// serving the API under `/v1` over TLS and HTTP/2 like bindle-server does
+func newNativeServerClient(t *testing.T) *client.Client {
+	t.Helper()
+	hostKey, hostPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bindleServer := server.New(storage.NewMemoryProvider(), server.WithHostKey(hostKey, hostPriv))
+	httpServer := httptest.NewUnstartedServer(http.StripPrefix("/v1", bindleServer))
+	httpServer.EnableHTTP2 = true
+	httpServer.StartTLS()
+	t.Cleanup(httpServer.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(httpServer.Certificate())
+	bindleClient, err := client.New(httpServer.URL+"/v1/", &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bindleClient
+}
+
+func conformanceSuccessful(t *testing.T, bindleClient *client.Client) {
+	// First try creating an invoice
+	inv := load_scaffold_invoice(t, "valid_v1")
+	_, err := bindleClient.CreateInvoice(inv)
+	if err != nil {
+		t.Fatalf("Unable to create invoice: %s", err)
+	}
+
+	// Now create the parcel associated with that invoice
+	data := load_scaffold_parcel_data(t, "valid_v1", "parcel")
+	if err := bindleClient.CreateParcel(inv.Name(), inv.Parcel[0].Label.SHA256, data); err != nil {
+		t.Fatalf("Unable to create parcel: %s", err)
+	}
+
+	// Now see if we get the parcel back from the server
+	serverData, err := bindleClient.GetParcel(inv.Name(), inv.Parcel[0].Label.SHA256)
+	if err != nil {
+		t.Fatalf("Unable to fetch parcel from server: %s", err)
+	}
+
+	if !reflect.DeepEqual(data, serverData) {
+		t.Fatalf("Did not get back valid data from the server\nExpected: %s\nGot: %s", data, serverData)
+	}
+
+	// Now try yanking the parcel and fetching it to make sure it gives us an error
+	if err := bindleClient.YankInvoice(inv.Name()); err != nil {
+		t.Fatalf("Unable to yank invoice: %s", err)
+	}
+
+	_, err = bindleClient.GetInvoice(inv.Name())
+	if err == nil {
+		t.Fatal("Shouldn't be able to get a yanked invoice")
+	}
+
+	// Get the yanked invoice and make sure it works
+	_, err = bindleClient.GetYankedInvoice(inv.Name())
+	if err != nil {
+		t.Fatalf("Should be able to get a yanked invoice: %s", err)
+	}
+}
+
+func conformanceStreamingSuccessful(t *testing.T, bindleClient *client.Client) {
+	// First try creating an invoice
+	resp, err := bindleClient.CreateInvoiceFromFile(scaffold_invoice_path("valid_v1"))
+	if err != nil {
+		t.Fatalf("Unable to create invoice: %s", err)
+	}
+
+	inv := resp.Invoice
+
+	// Now create the parcel associated with that invoice
+	data := load_scaffold_parcel_data(t, "valid_v1", "parcel")
+	if err := bindleClient.CreateParcelFromFile(inv.Name(), inv.Parcel[0].Label.SHA256, scaffold_parcel_path("valid_v1", "parcel")); err != nil {
+		t.Fatalf("Unable to create parcel: %s", err)
+	}
+
+	// Now see if we get the parcel back from the server
+	serverData, err := bindleClient.GetParcel(inv.Name(), inv.Parcel[0].Label.SHA256)
+	if err != nil {
+		t.Fatalf("Unable to fetch parcel from server: %s", err)
+	}
+
+	if !reflect.DeepEqual(data, serverData) {
+		t.Fatalf("Did not get back valid data from the server\nExpected: %s\nGot: %s", data, serverData)
+	}
+}
+
+func conformanceAlreadyCreated(t *testing.T, bindleClient *client.Client) {
+	// Create an invoice with two parcels
+	inv := load_scaffold_invoice(t, "valid_v2")
+	_, err := bindleClient.CreateInvoice(inv)
+	if err != nil {
+		t.Fatalf("Unable to create invoice: %s", err)
+	}
+
+	// Now create the parcels associated with that invoice
+	data := load_scaffold_parcel_data(t, "valid_v2", "other")
+	if err := bindleClient.CreateParcel(inv.Name(), inv.Parcel[0].Label.SHA256, data); err != nil {
+		t.Fatalf("Unable to create parcel: %s", err)
+	}
+
+	data = load_scaffold_parcel_data(t, "valid_v2", "parcel")
+	if err := bindleClient.CreateParcel(inv.Name(), inv.Parcel[1].Label.SHA256, data); err != nil {
+		t.Fatalf("Unable to create parcel: %s", err)
+	}
+
+	// Now create another invoice that already has all parcels existing
+	inv = load_scaffold_invoice(t, "valid_v1")
+	resp, err := bindleClient.CreateInvoice(inv)
+	if err != nil {
+		t.Fatalf("Unable to create invoice: %s", err)
+	}
+
+	if resp.Missing != nil {
+		t.Fatalf("Should have no missing parcels")
+	}
+}
+
+func conformanceMissing(t *testing.T, bindleClient *client.Client) {
+	// Create an invoice with two parcels
+	inv := load_scaffold_invoice(t, "valid_v2")
+	_, err := bindleClient.CreateInvoice(inv)
+	if err != nil {
+		t.Fatalf("Unable to create invoice: %s", err)
+	}
+
+	missing, err := bindleClient.GetMissingParcels(inv.Name())
+	if err != nil {
+		t.Fatalf("Should have been able to get missing parcels: %s", err)
+	}
+
+	if len(missing.Missing) != len(inv.Parcel) {
+		t.Fatalf("Expected to get %d missing parcels, got %d", len(inv.Parcel), len(missing.Missing))
+	}
+}
+
+func conformanceQuery(t *testing.T, bindleClient *client.Client) {
+	for _, name := range []string{"valid_v1", "valid_v2"} {
+		if _, err := bindleClient.CreateInvoice(load_scaffold_invoice(t, name)); err != nil {
+			t.Fatalf("Unable to create invoice: %s", err)
+		}
+	}
+	inv := load_scaffold_invoice(t, "valid_v2")
+
+	strict := true
+	matches, err := bindleClient.QueryInvoices(types.QueryOptions{Query: &inv.Bindle.Name, Version: &inv.Bindle.Version, Strict: &strict})
+	if err != nil {
+		t.Fatalf("Unable to query invoices: %s", err)
+	}
+	if len(matches.Invoices) != 1 || matches.Invoices[0].Name() != inv.Name() {
+		t.Fatalf("Expected to find only %s, got %d invoices", inv.Name(), len(matches.Invoices))
+	}
+
+	// Yanked invoices are only returned when asked for
+	if err := bindleClient.YankInvoice(inv.Name()); err != nil {
+		t.Fatalf("Unable to yank invoice: %s", err)
+	}
+	matches, err = bindleClient.QueryInvoices(types.QueryOptions{Query: &inv.Bindle.Name, Version: &inv.Bindle.Version, Strict: &strict})
+	if err != nil {
+		t.Fatalf("Unable to query invoices: %s", err)
+	}
+	if len(matches.Invoices) != 0 {
+		t.Fatalf("Yanked invoice should not be returned, got %d invoices", len(matches.Invoices))
+	}
+}
+
+func conformanceHostSignature(t *testing.T, bindleClient *client.Client) {
+	hostKeys, err := bindleClient.GetHostKeys(context.Background(), types.RoleHost)
+	if err != nil {
+		t.Fatalf("Unable to get host keys: %s", err)
+	}
+
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := bindleClient.CreateInvoice(inv); err != nil {
+		t.Fatalf("Unable to create invoice: %s", err)
+	}
+
+	fetched, err := bindleClient.GetInvoice(inv.Name())
+	if err != nil {
+		t.Fatalf("Unable to get invoice: %s", err)
+	}
+	if err := fetched.VerifyHostSignature(hostKeys.Key); err != nil {
+		t.Fatalf("Host signature should be valid: %s", err)
+	}
+}
@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/deislabs/go-bindle/bindletest"
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/server"
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// createLargeParcel creates a bindle with a single parcel of random data and returns the data
+func createLargeParcel(t *testing.T, bindleClient *client.Client, size int) (*types.Invoice, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(42)).Read(data)
+
+	inv := newTestInvoice(testAuthor)
+	inv.Parcel = []types.Parcel{types.NewParcel("large", "application/octet-stream", data)}
+	if _, err := bindleClient.CreateInvoice(*inv); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindleClient.CreateParcel(inv.Name(), inv.Parcel[0].Label.SHA256, data); err != nil {
+		t.Fatal(err)
+	}
+	return inv, data
+}
+
+func TestGetParcelRange(t *testing.T) {
+	server := newFakeServer(t, false)
+	bindleClient := newFakeClient(t, server)
+	inv, data := createLargeParcel(t, bindleClient, 1024)
+	sha := inv.Parcel[0].Label.SHA256
+
+	reader, err := bindleClient.GetParcelRange(inv.Name(), sha, 100, 50)
+	if err != nil {
+		t.Fatalf("Unable to get parcel range: %s", err)
+	}
+	part, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[100:150], part) {
+		t.Fatal("Got back the wrong part of the parcel")
+	}
+
+	reader, err = bindleClient.GetParcelRange(inv.Name(), sha, 1000, 0)
+	if err != nil {
+		t.Fatalf("Unable to get parcel range: %s", err)
+	}
+	part, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[1000:], part) {
+		t.Fatal("Got back the wrong part of the parcel")
+	}
+}
+
+func TestResumableDownload(t *testing.T) {
+	server := newFakeServer(t, false)
+	bindleClient := newFakeClient(t, server)
+	inv, data := createLargeParcel(t, bindleClient, 64*1024)
+	label := inv.Parcel[0].Label
+	path := filepath.Join(t.TempDir(), "parcel.dat")
+
+	// The transfer dies halfway, leaving a partial file behind
+	server.SetFaults(bindletest.Faults{TruncateBodies: true})
+	if err := bindleClient.DownloadParcel(inv.Name(), label, path); err == nil {
+		t.Fatal("Expected the download to fail")
+	}
+	info, err := os.Stat(path + ".partial")
+	if err != nil {
+		t.Fatalf("Expected a partial file: %s", err)
+	}
+	if info.Size() == 0 || uint64(info.Size()) >= label.Size {
+		t.Fatalf("Expected a partially downloaded file, got %d bytes", info.Size())
+	}
+
+	server.SetFaults(bindletest.Faults{})
+	if err := bindleClient.DownloadParcel(inv.Name(), label, path); err != nil {
+		t.Fatalf("Unable to resume download: %s", err)
+	}
+	downloaded, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, downloaded) {
+		t.Fatal("Resumed download has different data")
+	}
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Fatal("Partial file should have been moved into place")
+	}
+
+	// A partial file with the wrong data is detected once the download completes
+	if err := ioutil.WriteFile(path+".partial", []byte("not the right data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindleClient.DownloadParcel(inv.Name(), label, path); !errors.Is(err, client.ErrParcelMismatch) {
+		t.Fatalf("Expected a parcel mismatch error, got: %v", err)
+	}
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Fatal("Partial file should have been removed after failing verification")
+	}
+}
+
+func TestDownloadWithoutRangeSupport(t *testing.T) {
+	bindleServer := server.New(storage.NewMemoryProvider())
+	// Strip the Range header so the server always sends the whole parcel
+	httpServer := httptest.NewServer(http.StripPrefix("/v1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Range")
+		bindleServer.ServeHTTP(w, r)
+	})))
+	t.Cleanup(httpServer.Close)
+	bindleClient, err := client.New(httpServer.URL+"/v1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv, data := createLargeParcel(t, bindleClient, 4096)
+	label := inv.Parcel[0].Label
+
+	// Chunked downloads fall back to a single stream
+	chunkedPath := filepath.Join(t.TempDir(), "chunked.dat")
+	if err := bindleClient.DownloadParcel(inv.Name(), label, chunkedPath, client.WithParallelChunks(1024, 4)); err != nil {
+		t.Fatalf("Unable to download parcel in chunks: %s", err)
+	}
+	downloaded, err := ioutil.ReadFile(chunkedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, downloaded) {
+		t.Fatal("Downloaded parcel has different data")
+	}
+
+	if _, err := bindleClient.GetParcelRange(inv.Name(), label.SHA256, 10, 10); !errors.Is(err, client.ErrRangeNotSupported) {
+		t.Fatalf("Expected range not supported error, got: %v", err)
+	}
+
+	// The download starts over instead of resuming
+	path := filepath.Join(t.TempDir(), "parcel.dat")
+	if err := ioutil.WriteFile(path+".partial", data[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindleClient.DownloadParcel(inv.Name(), label, path); err != nil {
+		t.Fatalf("Unable to download parcel: %s", err)
+	}
+	downloaded, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, downloaded) {
+		t.Fatal("Downloaded parcel has different data")
+	}
+}
+
+func TestParallelChunkedDownload(t *testing.T) {
+	bindleServer := server.New(storage.NewMemoryProvider())
+	var lock sync.Mutex
+	var rangeRequests int
+	httpServer := httptest.NewUnstartedServer(http.StripPrefix("/v1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			lock.Lock()
+			rangeRequests++
+			lock.Unlock()
+		}
+		bindleServer.ServeHTTP(w, r)
+	})))
+	httpServer.EnableHTTP2 = true
+	httpServer.StartTLS()
+	t.Cleanup(httpServer.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(httpServer.Certificate())
+	bindleClient, err := client.New(httpServer.URL+"/v1", &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv, data := createLargeParcel(t, bindleClient, 256*1024+100)
+	path := filepath.Join(t.TempDir(), "parcel.dat")
+	if err := bindleClient.DownloadParcel(inv.Name(), inv.Parcel[0].Label, path, client.WithParallelChunks(16*1024, 4)); err != nil {
+		t.Fatalf("Unable to download parcel in chunks: %s", err)
+	}
+	downloaded, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, downloaded) {
+		t.Fatal("Reassembled parcel has different data")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if rangeRequests != 17 {
+		t.Fatalf("Expected 17 range requests, got %d", rangeRequests)
+	}
+}
+
+func TestChunkedDownloadFailure(t *testing.T) {
+	server := newFakeServer(t, false)
+	bindleClient := newFakeClient(t, server)
+	inv, data := createLargeParcel(t, bindleClient, 64*1024)
+	path := filepath.Join(t.TempDir(), "parcel.dat")
+
+	server.SetFaults(bindletest.Faults{TruncateBodies: true})
+	if err := bindleClient.DownloadParcel(inv.Name(), inv.Parcel[0].Label, path, client.WithParallelChunks(8*1024, 4)); err == nil {
+		t.Fatal("Expected truncated chunks to fail the download")
+	}
+
+	server.SetFaults(bindletest.Faults{})
+	if err := bindleClient.DownloadParcel(inv.Name(), inv.Parcel[0].Label, path, client.WithParallelChunks(8*1024, 4)); err != nil {
+		t.Fatalf("Unable to download parcel in chunks: %s", err)
+	}
+	downloaded, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, downloaded) {
+		t.Fatal("Reassembled parcel has different data")
+	}
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deislabs/go-bindle/internal/fsutil"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "data.toml")
+
+	unlock, err := fsutil.Lock(path, time.Second)
+	if err != nil {
+		t.Fatalf("Unable to take lock: %s", err)
+	}
+	if _, err := fsutil.Lock(path, 100*time.Millisecond); !errors.Is(err, fsutil.ErrLockTimeout) {
+		t.Fatalf("Expected a lock timeout while the lock is held, got %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatalf("Unable to release lock: %s", err)
+	}
+
+	unlock, err = fsutil.Lock(path, time.Second)
+	if err != nil {
+		t.Fatalf("Unable to take lock after it was released: %s", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	if err := ioutil.WriteFile(path, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every increment is a read-modify-write, so any overlap between holders loses an update
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := fsutil.Lock(path, 10*time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer unlock()
+
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			count, err := strconv.Atoi(string(raw))
+			if err != nil {
+				errs <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+			errs <- fsutil.WriteFileAtomic(path, []byte(strconv.Itoa(count+1)), 0644)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != strconv.Itoa(workers) {
+		t.Fatalf("Expected counter to be %d, got %s", workers, raw)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested")
+	path := filepath.Join(dir, "data.toml")
+
+	if err := fsutil.WriteFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("Unable to write file: %s", err)
+	}
+	if err := fsutil.WriteFileAtomic(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("Unable to overwrite file: %s", err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "second" {
+		t.Fatalf("Expected file to contain the last write, got %q", raw)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected file mode 0600, got %s", info.Mode().Perm())
+	}
+
+	// No temporary files should be left behind
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the written file in the directory, got %d entries", len(entries))
+	}
+}
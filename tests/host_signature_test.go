@@ -0,0 +1,244 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/keyring"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+// newHostSignedInvoice returns an invoice with the given name, signed with the host key if one is
+// given. The host is one of the authors, as only authors can sign
+func newHostSignedInvoice(t *testing.T, name string, hostKey *types.SignatureKey, hostPriv []byte) types.Invoice {
+	t.Helper()
+	inv := types.Invoice{
+		BindleVersion: "1.0.0",
+		Bindle: types.BindleSpec{
+			Name:    name,
+			Version: "1.0.0",
+			Authors: []string{testAuthor2},
+		},
+	}
+	if hostKey != nil {
+		if err := inv.GenerateSignature(testAuthor2, types.RoleHost, hostKey, hostPriv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return inv
+}
+
+// newStaticServer serves the TOML encoding of each value at its path
+func newStaticServer(t *testing.T, responses map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/toml")
+		if err := toml.NewEncoder(w).Encode(v); err != nil {
+			t.Errorf("Unable to encode response: %s", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientHostVerification(t *testing.T) {
+	hostKey, hostPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostorKey, impostorPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := newHostSignedInvoice(t, "example.com/signed", hostKey, hostPriv)
+	unsigned := newHostSignedInvoice(t, "example.com/unsigned", nil, nil)
+	impostor := newHostSignedInvoice(t, "example.com/impostor", impostorKey, impostorPriv)
+	server := newStaticServer(t, map[string]interface{}{
+		"/_i/" + signed.Name():   signed,
+		"/_i/" + unsigned.Name(): unsigned,
+		"/_i/" + impostor.Name(): impostor,
+		"/_q": types.Matches{
+			Total:    2,
+			Invoices: []types.Invoice{signed, impostor},
+		},
+	})
+
+	trusted := &types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*hostKey}}
+	bindleClient, err := client.New(server.URL, nil, client.WithHostVerification(trusted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bindleClient.GetInvoice(signed.Name()); err != nil {
+		t.Fatalf("Invoice signed by the trusted host should be returned: %s", err)
+	}
+	if _, err := bindleClient.GetInvoice(unsigned.Name()); !errors.Is(err, types.ErrMissingHostSignature) {
+		t.Fatalf("Expected an invoice without a host signature to be refused, got: %v", err)
+	}
+	if _, err := bindleClient.GetInvoice(impostor.Name()); !errors.Is(err, types.ErrMissingSignatureKey) {
+		t.Fatalf("Expected an invoice signed by another host to be refused, got: %v", err)
+	}
+	if _, err := bindleClient.QueryInvoices(types.QueryOptions{}); !errors.Is(err, types.ErrMissingSignatureKey) {
+		t.Fatalf("Expected a query returning an invoice signed by another host to be refused, got: %v", err)
+	}
+
+	// Without host verification, the invoices are returned as is
+	unverified, err := client.New(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unverified.GetInvoice(impostor.Name()); err != nil {
+		t.Fatalf("Host signatures should not be checked without host verification: %s", err)
+	}
+}
+
+// useTempConfigDir points the user config directory, and with it the local keyring, at a temporary
+// directory for the duration of the test. The bindle config directory is created in it
+func useTempConfigDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"XDG_CONFIG_HOME", "HOME"} {
+		old, set := os.LookupEnv(name)
+		os.Setenv(name, dir)
+		name := name
+		t.Cleanup(func() {
+			if set {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+
+	config, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(config, "bindle"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetHostKeys(t *testing.T) {
+	useTempConfigDir(t)
+
+	hostKey, hostPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := newHostSignedInvoice(t, "example.com/signed", hostKey, hostPriv)
+	hostKeys := types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*hostKey}}
+	var requestedRoles []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v interface{}
+		switch r.URL.Path {
+		case "/bindle-keys":
+			requestedRoles = append(requestedRoles, r.URL.Query().Get("roles"))
+			v = hostKeys
+		case "/_i/" + signed.Name():
+			v = signed
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/toml")
+		toml.NewEncoder(w).Encode(v)
+	}))
+	t.Cleanup(server.Close)
+
+	bindleClient, err := client.New(server.URL, nil, client.WithHostVerification(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := bindleClient.GetHostKeys(context.Background(), types.RoleHost, types.RoleProxy)
+	if err != nil {
+		t.Fatalf("Unable to get host keys: %s", err)
+	}
+	if len(fetched.Key) != 1 || fetched.Key[0].Key != hostKey.Key {
+		t.Fatalf("Unexpected host keys: %v", fetched.Key)
+	}
+	if requestedRoles[0] != "host,proxy" {
+		t.Fatalf("Expected the roles to be sent to the server, got %q", requestedRoles[0])
+	}
+
+	// Host keys are fetched from the server when host verification has no keys configured
+	if _, err := bindleClient.GetInvoice(signed.Name()); err != nil {
+		t.Fatalf("Invoice should be verified with the keys from the server: %s", err)
+	}
+	if len(requestedRoles) != 2 || requestedRoles[1] != types.RoleHost {
+		t.Fatalf("Expected the host keys to be fetched once for verification, got requests for %v", requestedRoles)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := bindleClient.GetHostKeys(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a canceled request, got: %v", err)
+	}
+}
+
+func TestTrustHostKeys(t *testing.T) {
+	useTempConfigDir(t)
+
+	hostKey, _, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeys := &types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*hostKey}}
+
+	// The first time a host key is seen it is trusted, after that nothing changes
+	for i := 0; i < 2; i++ {
+		changes, err := keyring.TrustHostKeys(hostKeys)
+		if err != nil || len(changes) != 0 {
+			t.Fatalf("Expected host key to be trusted without changes, got %v (err: %v)", changes, err)
+		}
+	}
+	trusted, err := keyring.LocalKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trusted.Key) != 1 || trusted.Key[0].Key != hostKey.Key {
+		t.Fatalf("Expected only the host key to be trusted, got %v", trusted.Key)
+	}
+
+	// A different key for the same label is reported and not trusted
+	changedKey, _, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := keyring.TrustHostKeys(&types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*changedKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].TrustedKey != hostKey.Key || changes[0].NewKey != changedKey.Key {
+		t.Fatalf("Expected the host key change to be reported, got %v", changes)
+	}
+	trusted, err = keyring.LocalKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trusted.Key) != 1 || trusted.Key[0].Key != hostKey.Key {
+		t.Fatal("A changed host key should not be trusted automatically")
+	}
+
+	// Keys with a forged label signature are refused
+	forged := *changedKey
+	forged.Label = "someone else"
+	if _, err := keyring.TrustHostKeys(&types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{forged}}); !errors.Is(err, types.ErrInvalidSignatureKey) {
+		t.Fatalf("Expected a key with an invalid label signature to be refused, got: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+func TestInvoiceJSON(t *testing.T) {
+	inv := load_scaffold_invoice(t, "valid_v2")
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		t.Fatalf("Unable to marshal invoice to JSON: %s", err)
+	}
+
+	// Field names should match the serde names used by bindle
+	for _, field := range []string{`"bindleVersion"`, `"mediaType"`, `"sha256"`, `"parcel"`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("Expected JSON to contain %s: %s", field, data)
+		}
+	}
+	// Unset optional fields should be left out entirely rather than set to null
+	if strings.Contains(string(data), "null") {
+		t.Errorf("JSON should not contain null values: %s", data)
+	}
+
+	var decoded types.Invoice
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unable to unmarshal invoice from JSON: %s", err)
+	}
+	if !reflect.DeepEqual(inv, decoded) {
+		t.Fatalf("Invoice does not round trip through JSON\nExpected: %+v\nGot: %+v", inv, decoded)
+	}
+}
+
+func TestInvoiceJSONSchema(t *testing.T) {
+	raw, err := types.InvoiceJSONSchema()
+	if err != nil {
+		t.Fatalf("Unable to generate schema: %s", err)
+	}
+
+	var schema struct {
+		Required    []string                   `json:"required"`
+		Properties  map[string]json.RawMessage `json:"properties"`
+		Definitions map[string]struct {
+			Required []string `json:"required"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %s", err)
+	}
+
+	if !reflect.DeepEqual(schema.Required, []string{"bindleVersion", "bindle"}) {
+		t.Errorf("Unexpected required invoice fields: %v", schema.Required)
+	}
+	if _, ok := schema.Properties["yanked"]; !ok {
+		t.Error("Schema should include optional fields")
+	}
+	label, ok := schema.Definitions["Label"]
+	if !ok {
+		t.Fatal("Schema should define the Label type")
+	}
+	if !reflect.DeepEqual(label.Required, []string{"sha256", "mediaType", "name", "size"}) {
+		t.Errorf("Unexpected required label fields: %v", label.Required)
+	}
+}
+
+func TestInvoiceJSONSchemaValidation(t *testing.T) {
+	raw, err := types.InvoiceJSONSchema()
+	if err != nil {
+		t.Fatalf("Unable to generate schema: %s", err)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		t.Fatalf("Generated schema is not a valid JSON schema: %s", err)
+	}
+
+	for _, name := range []string{"valid_v1", "valid_v2", "lotsa_parcels"} {
+		result, err := schema.Validate(gojsonschema.NewGoLoader(load_scaffold_invoice(t, name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid() {
+			t.Errorf("Expected invoice %s to be valid: %v", name, result.Errors())
+		}
+	}
+
+	// An invoice missing its version with a parcel size that isn't a number
+	invalid := map[string]interface{}{
+		"bindleVersion": "1.0.0",
+		"bindle":        map[string]interface{}{"name": "example.com/invalid"},
+		"parcel": []interface{}{map[string]interface{}{"label": map[string]interface{}{
+			"sha256":    "e1706ab0a39ac88094b6d54a3f5cdba41fe5a901",
+			"mediaType": "text/plain",
+			"name":      "parcel.txt",
+			"size":      "large",
+		}}},
+	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(invalid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]bool{}
+	for _, resultErr := range result.Errors() {
+		fields[resultErr.Field()] = true
+	}
+	for _, field := range []string{"bindle", "parcel.0.label.size"} {
+		if !fields[field] {
+			t.Errorf("Expected a validation error for %s, got: %v", field, result.Errors())
+		}
+	}
+}
+
+func TestInvoiceYAML(t *testing.T) {
+	inv := load_scaffold_invoice(t, "valid_v2")
+
+	data, err := yaml.Marshal(inv)
+	if err != nil {
+		t.Fatalf("Unable to marshal invoice to YAML: %s", err)
+	}
+	for _, field := range []string{"bindleVersion:", "mediaType:", "sha256:", "parcel:"} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("Expected YAML to contain %s: %s", field, data)
+		}
+	}
+
+	var decoded types.Invoice
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unable to unmarshal invoice from YAML: %s", err)
+	}
+	if !reflect.DeepEqual(inv, decoded) {
+		t.Fatalf("Invoice does not round trip through YAML\nExpected: %+v\nGot: %+v", inv, decoded)
+	}
+}
+
+func TestVerificationReportEncoding(t *testing.T) {
+	report := types.VerificationReport{
+		Strategy: types.VerificationExhaustive,
+		Results: []types.SignatureResult{{
+			Signature: types.Signature{By: testAuthor, Role: types.RoleCreator},
+			Status:    types.SignatureBad,
+			Err:       types.ErrInvalidSignature,
+		}},
+	}
+
+	jsonData, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Unable to marshal report to JSON: %s", err)
+	}
+	yamlData, err := yaml.Marshal(report)
+	if err != nil {
+		t.Fatalf("Unable to marshal report to YAML: %s", err)
+	}
+
+	// Both encodings should include the status and the failure reason as strings
+	type encodedReport struct {
+		Strategy string `json:"strategy" yaml:"strategy"`
+		Results  []struct {
+			Signature types.Signature `json:"signature" yaml:"signature"`
+			Status    string          `json:"status" yaml:"status"`
+			Error     string          `json:"error" yaml:"error"`
+		} `json:"results" yaml:"results"`
+	}
+	var fromJSON, fromYAML encodedReport
+	if err := json.Unmarshal(jsonData, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(yamlData, &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Fatalf("JSON and YAML reports differ\nJSON: %s\nYAML: %s", jsonData, yamlData)
+	}
+	if fromYAML.Strategy != "exhaustive" || len(fromYAML.Results) != 1 {
+		t.Fatalf("Unexpected encoded report: %s", yamlData)
+	}
+	result := fromYAML.Results[0]
+	if result.Signature.By != testAuthor || result.Status != types.SignatureBad.String() || result.Error != types.ErrInvalidSignature.Error() {
+		t.Fatalf("Expected the signature, status and error to be encoded, got: %s", yamlData)
+	}
+}
@@ -0,0 +1,518 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/deislabs/go-bindle/keyring"
+	"github.com/deislabs/go-bindle/types"
+)
+
+func TestKeyringManagement(t *testing.T) {
+	key1, _, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, _, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key3, _, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleApprover)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr := types.Keyring{Version: "1.0.0"}
+	if !kr.Add(*key1) || !kr.Add(*key2) || !kr.Add(*key3) {
+		t.Fatal("Should have added all new keys")
+	}
+
+	// Adding the same public key again should only merge the roles
+	dup := *key1
+	dup.Roles = []string{types.RoleApprover}
+	if kr.Add(dup) {
+		t.Fatal("Should not add a duplicate public key")
+	}
+	if len(kr.Key) != 3 {
+		t.Fatalf("Expected 3 keys, got %d", len(kr.Key))
+	}
+	if !kr.FindByKey(key1.Key).IncludesRole(types.RoleApprover) {
+		t.Fatal("Roles of the duplicate key should have been merged")
+	}
+
+	if found := kr.FindByLabel(testAuthor); len(found) != 2 {
+		t.Fatalf("Expected 2 keys for %s, got %d", testAuthor, len(found))
+	}
+	if approvers := kr.List(types.RoleApprover); len(approvers) != 2 {
+		t.Fatalf("Expected 2 approver keys, got %d", len(approvers))
+	}
+
+	rotated, _, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleApprover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Rotate(key3.Key, *rotated); err != nil {
+		t.Fatalf("Unable to rotate key: %s", err)
+	}
+	if kr.FindByKey(key3.Key) != nil || kr.FindByKey(rotated.Key) == nil {
+		t.Fatal("Key was not rotated")
+	}
+	if err := kr.Rotate(key3.Key, *rotated); !errors.Is(err, types.ErrKeyNotFound) {
+		t.Fatalf("Expected key not found error, got: %v", err)
+	}
+
+	if !kr.Remove(key2.Key) || kr.Remove(key2.Key) {
+		t.Fatal("Key should only be removed once")
+	}
+
+	other := types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*key1, *key2}}
+	if added := kr.Merge(&other); added != 1 {
+		t.Fatalf("Expected 1 key to be merged, got %d", added)
+	}
+
+	kr.Key = append(kr.Key, *key2, *key2)
+	if removed := kr.Dedupe(); removed != 2 {
+		t.Fatalf("Expected 2 duplicates to be removed, got %d", removed)
+	}
+
+	if removed := kr.RemoveLabel(testAuthor); removed != 2 || len(kr.Key) != 1 {
+		t.Fatalf("Expected to remove 2 keys leaving 1, removed %d leaving %d", removed, len(kr.Key))
+	}
+}
+
+// keyringWorkerEnvVar makes TestConcurrentKeyringUpdates act as one of the processes adding keys
+const keyringWorkerEnvVar = "BINDLE_TEST_KEYRING_WORKER"
+
+const keysPerKeyringWorker = 10
+
+// addKeyringWorkerKeys adds keys to the local keyring
+func addKeyringWorkerKeys(worker string) error {
+	for i := 0; i < keysPerKeyringWorker; i++ {
+		key, _, err := keyring.GenerateSignatureKey(fmt.Sprintf("worker %s key %d", worker, i), types.RoleCreator)
+		if err != nil {
+			return err
+		}
+		if err := keyring.AddLocalKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestConcurrentKeyringUpdates(t *testing.T) {
+	if worker := os.Getenv(keyringWorkerEnvVar); worker != "" {
+		// The parent process already pointed the config directory at its temporary directory
+		if err := addKeyringWorkerKeys(worker); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	useTempConfigDir(t)
+
+	// Add keys from several processes, like concurrent CI jobs, and several goroutines at once
+	const processes = 3
+	const goroutines = 3
+	var wg sync.WaitGroup
+	errs := make(chan error, processes+goroutines)
+	for i := 0; i < processes; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestConcurrentKeyringUpdates$")
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=process%d", keyringWorkerEnvVar, i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("worker process failed: %w\n%s", err, out)
+			}
+		}()
+	}
+	for i := 0; i < goroutines; i++ {
+		worker := fmt.Sprintf("goroutine%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := addKeyringWorkerKeys(worker); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	keys, err := keyring.LocalKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (processes + goroutines) * keysPerKeyringWorker; len(keys.Key) != expected {
+		t.Fatalf("Expected %d keys after concurrent updates, got %d", expected, len(keys.Key))
+	}
+}
+
+func TestKeyringStoreLayers(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "*")
+	if err != nil {
+		t.Fatalf("Unable to create tempdir for testing: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tempdir) })
+
+	// Point the default keyring at the tempdir so we don't touch the real one
+	userPath := filepath.Join(tempdir, "user", "keyring.toml")
+	os.Setenv(keyring.KeyringEnvVar, userPath)
+	t.Cleanup(func() { os.Unsetenv(keyring.KeyringEnvVar) })
+
+	userKey, _, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.AddLocalKey(userKey); err != nil {
+		t.Fatalf("Unable to add key to local keyring: %s", err)
+	}
+	// Adding the key a second time should not create a duplicate
+	if err := keyring.AddLocalKey(userKey); err != nil {
+		t.Fatalf("Unable to add key to local keyring: %s", err)
+	}
+
+	local, err := keyring.LocalKeyring()
+	if err != nil {
+		t.Fatalf("Unable to load local keyring: %s", err)
+	}
+	if len(local.Key) != 1 {
+		t.Fatalf("Expected 1 key in the local keyring, got %d", len(local.Key))
+	}
+
+	project := keyring.NewStore(keyring.ProjectKeyringPath(filepath.Join(tempdir, "project")))
+	projectKey, _, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleApprover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.Add(projectKey); err != nil {
+		t.Fatalf("Unable to add key to project keyring: %s", err)
+	}
+
+	user, err := keyring.DefaultStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := keyring.NewStore(filepath.Join(tempdir, "nonexistent", "keyring.toml"))
+
+	// A more specific layer can't widen the roles of a key from a more general layer
+	widened := *userKey
+	widened.Roles = []string{types.RoleApprover}
+	if err := project.Add(&widened); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := keyring.LayeredKeyring(missing, user, project)
+	if err != nil {
+		t.Fatalf("Unable to load layered keyring: %s", err)
+	}
+	if len(merged.Key) != 2 {
+		t.Fatalf("Expected 2 keys in the layered keyring, got %d", len(merged.Key))
+	}
+	if key := merged.FindByKey(userKey.Key); key == nil || !reflect.DeepEqual(key.Roles, []string{types.RoleCreator}) {
+		t.Fatalf("Expected the user key to keep only its user keyring roles, got %v", key)
+	}
+
+	// The project keyring is only used when a project directory is given explicitly
+	layers, err := keyring.DefaultLayers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 2 || layers[1].Path != userPath {
+		t.Fatalf("Expected only the system and user layers by default, got %d layers", len(layers))
+	}
+	projectDir := filepath.Join(tempdir, "project")
+	layers, err = keyring.DefaultLayers(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 3 || layers[2].Path != keyring.ProjectKeyringPath(projectDir) {
+		t.Fatalf("Expected the project layer to be added, got %d layers", len(layers))
+	}
+
+	// Trusting a host key for a label should only happen once
+	hostKey, _, err := keyring.GenerateSignatureKey("bindle.example.com", types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := project.TrustHostKeys(&types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*hostKey}})
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("Expected host key to be trusted without changes, got %v (err: %v)", changes, err)
+	}
+
+	changedHostKey, _, err := keyring.GenerateSignatureKey("bindle.example.com", types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = project.TrustHostKeys(&types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*changedHostKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].TrustedKey != hostKey.Key {
+		t.Fatalf("Expected a single host key change, got %v", changes)
+	}
+
+	projectKeyring, err := project.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectKeyring.FindByKey(changedHostKey.Key) != nil {
+		t.Fatal("A changed host key should not be trusted automatically")
+	}
+}
+
+func TestSecretKeyStore(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "*")
+	if err != nil {
+		t.Fatalf("Unable to create tempdir for testing: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tempdir) })
+
+	creatorKey, creatorPriv, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, approverPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleApprover)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := keyring.NewSecretKeyStore()
+	if _, err := store.Add(testAuthor, creatorPriv, types.RoleCreator); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(testAuthor2, approverPriv, types.RoleApprover); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(testAuthor, creatorPriv, "superuser"); !errors.Is(err, types.ErrInvalidRole) {
+		t.Fatalf("Expected invalid role error, got: %v", err)
+	}
+
+	// Round trip through the exported format to make sure nothing is lost
+	rustPath := filepath.Join(tempdir, "secret_keys.toml")
+	if err := store.ExportRust(rustPath); err != nil {
+		t.Fatalf("Unable to export secret keys: %s", err)
+	}
+	imported, err := keyring.ImportRustSecretKeys(rustPath)
+	if err != nil {
+		t.Fatalf("Unable to import secret keys: %s", err)
+	}
+	if len(imported.Key) != 2 {
+		t.Fatalf("Expected 2 imported keys, got %d", len(imported.Key))
+	}
+
+	if _, err := imported.Select(testAuthor, types.RoleApprover); !errors.Is(err, keyring.ErrSecretKeyNotFound) {
+		t.Fatalf("Expected secret key not found error, got: %v", err)
+	}
+
+	entry, err := imported.Select(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatalf("Unable to select key: %s", err)
+	}
+	sigKey, err := entry.SignatureKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sigKey.Key != creatorKey.Key || sigKey.LabelSignature != creatorKey.LabelSignature {
+		t.Fatal("Imported key does not match the original signature key")
+	}
+
+	invoice := &types.Invoice{
+		BindleVersion: "1.0.0",
+		Bindle: types.BindleSpec{
+			Name:    "importantproj",
+			Version: "0.1.0",
+			Authors: []string{testAuthor},
+		},
+		Parcel: []types.Parcel{
+			types.NewParcel("importantfile", "application/important", []byte("something very important")),
+		},
+	}
+	if err := imported.SignInvoice(invoice, testAuthor, types.RoleCreator); err != nil {
+		t.Fatalf("Unable to sign invoice: %s", err)
+	}
+	if err := invoice.VerifySignatures([]types.SignatureKey{*creatorKey}, types.VerificationExhaustive); err != nil {
+		t.Fatalf("Signature from the secret key store is not valid: %s", err)
+	}
+}
+
+func TestEncryptedPrivKey(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "*")
+	if err != nil {
+		t.Fatalf("Unable to create tempdir for testing: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tempdir) })
+
+	_, privKey, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(tempdir, "private.key")
+	if err := keyring.WritePrivKey(privKey, keyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	passphrase := func() ([]byte, error) { return []byte("correct horse battery staple"), nil }
+	if err := keyring.EncryptPrivKeyFile(keyPath, passphrase); err != nil {
+		t.Fatalf("Unable to encrypt private key file: %s", err)
+	}
+
+	if _, err := keyring.ReadPrivKey(keyPath); !errors.Is(err, keyring.ErrEncryptedPrivKey) {
+		t.Fatalf("Expected encrypted private key error, got: %v", err)
+	}
+
+	wrongPassphrase := func() ([]byte, error) { return []byte("hunter2"), nil }
+	if _, err := keyring.ReadPrivKeyWithPassphrase(keyPath, wrongPassphrase); !errors.Is(err, keyring.ErrIncorrectPassphrase) {
+		t.Fatalf("Expected incorrect passphrase error, got: %v", err)
+	}
+
+	decrypted, err := keyring.ReadPrivKeyWithPassphrase(keyPath, passphrase)
+	if err != nil {
+		t.Fatalf("Unable to decrypt private key: %s", err)
+	}
+	if !reflect.DeepEqual([]byte(privKey), decrypted) {
+		t.Fatal("Decrypted private key does not match the original")
+	}
+}
+
+func TestEncryptedPrivKeyScryptLimits(t *testing.T) {
+	_, privKey, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := []byte("correct horse battery staple")
+	encrypted, err := keyring.EncryptPrivKey(privKey, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tampered key file must be rejected before scrypt runs, otherwise these would try to
+	// allocate far more memory than is available
+	for _, params := range []struct{ from, to string }{
+		{"n = 32768\n", "n = 1073741824\n"},
+		// Both are within their own limit, but together need 1 GiB
+		{"n = 32768\n", "n = 1048576\n"},
+		{"r = 8\n", "r = 1024\n"},
+		{"p = 1\n", "p = 64\n"},
+		{"n = 32768\n", "n = 30000\n"},
+		{"n = 32768\n", "n = 0\n"},
+	} {
+		tampered := bytes.Replace(encrypted, []byte(params.from), []byte(params.to), 1)
+		if bytes.Equal(tampered, encrypted) {
+			t.Fatalf("Unexpected encrypted key format, unable to replace %q", params.from)
+		}
+		if _, err := keyring.DecryptPrivKey(tampered, passphrase); !errors.Is(err, keyring.ErrUnsupportedEnvelope) {
+			t.Fatalf("Expected unsupported envelope error for %q, got: %v", params.to, err)
+		}
+	}
+
+	// Anything but an Ed25519 private key is refused once decrypted
+	short, err := keyring.EncryptPrivKey(privKey[:16], passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.DecryptPrivKey(short, passphrase); !errors.Is(err, keyring.ErrInvalidPrivKey) {
+		t.Fatalf("Expected invalid private key error, got: %v", err)
+	}
+}
+
+func TestKeyFormats(t *testing.T) {
+	sigKey, privKey, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8, err := keyring.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatalf("Unable to marshal PKCS#8 key: %s", err)
+	}
+	parsed, err := keyring.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		t.Fatalf("Unable to parse PKCS#8 key: %s", err)
+	}
+	if !reflect.DeepEqual([]byte(privKey), parsed) {
+		t.Fatal("PKCS#8 private key does not match the original")
+	}
+
+	openssh, err := keyring.MarshalOpenSSHPrivateKey(privKey, "testy@test.face")
+	if err != nil {
+		t.Fatalf("Unable to marshal OpenSSH key: %s", err)
+	}
+	parsed, err = keyring.ParseOpenSSHPrivateKey(openssh, nil)
+	if err != nil {
+		t.Fatalf("Unable to parse OpenSSH key: %s", err)
+	}
+	if !reflect.DeepEqual([]byte(privKey), parsed) {
+		t.Fatal("OpenSSH private key does not match the original")
+	}
+
+	pkix, err := keyring.MarshalPKIXPublicKey(sigKey.Key)
+	if err != nil {
+		t.Fatalf("Unable to marshal PKIX key: %s", err)
+	}
+	if pub, err := keyring.ParsePKIXPublicKey(pkix); err != nil || pub != sigKey.Key {
+		t.Fatalf("PKIX public key does not match the original (err: %v)", err)
+	}
+
+	authorized, err := keyring.MarshalOpenSSHPublicKey(sigKey.Key, "testy@test.face")
+	if err != nil {
+		t.Fatalf("Unable to marshal OpenSSH public key: %s", err)
+	}
+	if pub, err := keyring.ParseOpenSSHPublicKey(authorized); err != nil || pub != sigKey.Key {
+		t.Fatalf("OpenSSH public key does not match the original (err: %v)", err)
+	}
+}
+
+func TestMultiRoleKeys(t *testing.T) {
+	if _, _, err := keyring.GenerateSignatureKey(testAuthor); !errors.Is(err, types.ErrInvalidRole) {
+		t.Fatalf("Expected invalid role error without roles, got: %v", err)
+	}
+	if _, _, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator, "superuser"); !errors.Is(err, types.ErrInvalidRole) {
+		t.Fatalf("Expected invalid role error, got: %v", err)
+	}
+
+	sigKey, privKey, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator, types.RoleApprover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sigKey.IncludesRole(types.RoleCreator) || !sigKey.IncludesRole(types.RoleApprover) {
+		t.Fatalf("Key should include both roles, got %v", sigKey.Roles)
+	}
+
+	if err := keyring.AddRoles(sigKey, privKey, types.RoleProxy); err != nil {
+		t.Fatalf("Unable to add role: %s", err)
+	}
+	if err := keyring.RemoveRoles(sigKey, privKey, types.RoleCreator); err != nil {
+		t.Fatalf("Unable to remove role: %s", err)
+	}
+	if sigKey.IncludesRole(types.RoleCreator) || !sigKey.IncludesRole(types.RoleProxy) {
+		t.Fatalf("Unexpected roles after update: %v", sigKey.Roles)
+	}
+	if err := sigKey.VerifyLabel(); err != nil {
+		t.Fatalf("Label signature should still be valid: %s", err)
+	}
+
+	if err := keyring.RemoveRoles(sigKey, privKey, types.RoleApprover, types.RoleProxy); !errors.Is(err, types.ErrInvalidRole) {
+		t.Fatalf("Should not be able to remove every role, got: %v", err)
+	}
+
+	_, otherPriv, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.AddRoles(sigKey, otherPriv, types.RoleHost); !errors.Is(err, keyring.ErrKeyMismatch) {
+		t.Fatalf("Expected key mismatch error, got: %v", err)
+	}
+}
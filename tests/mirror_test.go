@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/deislabs/go-bindle/mirror"
+	"github.com/deislabs/go-bindle/types"
+)
+
+func TestMirror(t *testing.T) {
+	sourceServer := newFakeServer(t, false)
+	destServer := newFakeServer(t, false)
+	source := newFakeClient(t, sourceServer)
+	dest := newFakeClient(t, destServer)
+
+	v1 := load_scaffold_invoice(t, "valid_v1")
+	v2 := load_scaffold_invoice(t, "valid_v2")
+	for _, inv := range []types.Invoice{v1, v2} {
+		if _, err := source.CreateInvoice(inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parcels := map[string][]byte{
+		v2.Parcel[0].Label.SHA256: load_scaffold_parcel_data(t, "valid_v2", "other"),
+		v2.Parcel[1].Label.SHA256: load_scaffold_parcel_data(t, "valid_v2", "parcel"),
+	}
+	for sha, data := range parcels {
+		if err := source.CreateParcel(v2.Name(), sha, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate an interrupted sync where only the invoice of one bindle made it
+	if _, err := dest.CreateInvoice(v2); err != nil {
+		t.Fatal(err)
+	}
+
+	m := mirror.New(source, dest, mirror.WithConcurrency(2))
+	plan, err := m.Plan([]string{v1.Name(), v2.Name()})
+	if err != nil {
+		t.Fatalf("Unable to plan sync: %s", err)
+	}
+	if !plan.Bindles[0].CreateInvoice || len(plan.Bindles[0].Parcels) != 1 {
+		t.Fatalf("Expected %s to need creating with its parcel, got %+v", v1.Name(), plan.Bindles[0])
+	}
+	if plan.Bindles[1].CreateInvoice || len(plan.Bindles[1].Parcels) != 2 {
+		t.Fatalf("Expected %s to only need its parcels, got %+v", v2.Name(), plan.Bindles[1])
+	}
+	// Planning doesn't change the destination
+	if _, err := dest.GetInvoice(v1.Name()); err == nil {
+		t.Fatal("Planning should not create invoices")
+	}
+
+	if _, err := m.Sync([]string{v1.Name(), v2.Name()}); err != nil {
+		t.Fatalf("Unable to sync: %s", err)
+	}
+	for sha, data := range parcels {
+		copied, err := dest.GetParcel(v2.Name(), sha)
+		if err != nil {
+			t.Fatalf("Parcel %s should have been copied: %s", sha, err)
+		}
+		if !bytes.Equal(data, copied) {
+			t.Fatalf("Copied parcel %s has different data", sha)
+		}
+	}
+
+	// The source's host signature is preserved next to the destination's
+	mirrored, err := dest.GetInvoice(v1.Name())
+	if err != nil {
+		t.Fatalf("Invoice should have been copied: %s", err)
+	}
+	if err := mirrored.VerifyHostSignature([]types.SignatureKey{*sourceServer.HostKey}); err != nil {
+		t.Fatalf("Source host signature should have been preserved: %s", err)
+	}
+	if err := mirrored.VerifyHostSignature([]types.SignatureKey{*destServer.HostKey}); err != nil {
+		t.Fatalf("Destination should have signed the invoice: %s", err)
+	}
+
+	// Yanks are synced and everything else is now up to date
+	if err := source.YankInvoice(v1.Name()); err != nil {
+		t.Fatal(err)
+	}
+	query := "enterprise.com"
+	yanked := true
+	plan, err = m.PlanQuery(types.QueryOptions{Query: &query, Yanked: &yanked})
+	if err != nil {
+		t.Fatalf("Unable to plan sync: %s", err)
+	}
+	if len(plan.Bindles) != 2 {
+		t.Fatalf("Expected the query to match 2 bindles, got %d", len(plan.Bindles))
+	}
+	for _, b := range plan.Bindles {
+		if b.ID == v1.Name() && !b.Yank {
+			t.Fatalf("Expected %s to need yanking", b.ID)
+		}
+		if b.ID == v2.Name() && !b.UpToDate() {
+			t.Fatalf("Expected %s to be up to date, got %+v", b.ID, b)
+		}
+	}
+	if _, err := m.SyncQuery(types.QueryOptions{Query: &query, Yanked: &yanked}); err != nil {
+		t.Fatalf("Unable to sync: %s", err)
+	}
+	if _, err := dest.GetInvoice(v1.Name()); err == nil {
+		t.Fatal("Invoice should have been yanked on the destination")
+	}
+
+	// Bindles missing on the source are reported per bindle
+	result, err := m.Sync([]string{"nonexistent/1.0.0", v2.Name()})
+	if err == nil {
+		t.Fatal("Expected an error for a bindle missing on the source")
+	}
+	if failed := result.Failed(); len(failed) != 1 || failed[0].ID != "nonexistent/1.0.0" {
+		t.Fatalf("Expected only the missing bindle to fail, got %+v", failed)
+	}
+}
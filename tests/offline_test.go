@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/deislabs/go-bindle/bindletest"
+	"github.com/deislabs/go-bindle/cache"
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/keyring"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestOfflineMode(t *testing.T) {
+	server := newFakeServer(t, false)
+	localCache, err := cache.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Client(client.WithOfflineMode(client.OfflineFallback)); err == nil {
+		t.Fatal("Offline mode should require a cache")
+	}
+
+	bindleClient := newFakeClient(t, server, client.WithCache(localCache), client.WithOfflineMode(client.OfflineFallback))
+	cached := load_scaffold_invoice(t, "valid_v1")
+	uncached := load_scaffold_invoice(t, "valid_v2")
+	for _, inv := range []types.Invoice{cached, uncached} {
+		if _, err := bindleClient.CreateInvoice(inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sha := cached.Parcel[0].Label.SHA256
+	if err := bindleClient.CreateParcel(cached.Name(), sha, load_scaffold_parcel_data(t, "valid_v1", "parcel")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pull the first bindle so it is cached
+	if _, err := bindleClient.GetInvoice(cached.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bindleClient.GetParcel(cached.Name(), sha); err != nil {
+		t.Fatal(err)
+	}
+
+	// A query the server rejects is not a server failure, so it isn't answered from the cache
+	server.SetFaults(bindletest.Faults{ErrorStatus: http.StatusBadRequest})
+	if _, err := bindleClient.QueryInvoices(types.QueryOptions{}); err == nil || errors.Is(err, client.ErrServerUnavailable) {
+		t.Fatalf("Expected the query to fail with the server's error, got: %v", err)
+	}
+
+	server.SetFaults(bindletest.Faults{ErrorStatus: http.StatusServiceUnavailable})
+	if _, err := bindleClient.GetInvoice(cached.Name()); err != nil {
+		t.Fatalf("Cached invoice should be available while the server is down: %s", err)
+	}
+	reader, err := bindleClient.GetParcelReader(cached.Name(), sha)
+	if err != nil {
+		t.Fatalf("Cached parcel should be available while the server is down: %s", err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+	matches, err := bindleClient.QueryInvoices(types.QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query should be answered from the cache: %s", err)
+	}
+	if len(matches.Invoices) != 1 || matches.Invoices[0].Name() != cached.Name() {
+		t.Fatalf("Expected only the cached invoice to match, got %d invoices", len(matches.Invoices))
+	}
+	if _, err := bindleClient.GetInvoice(uncached.Name()); !errors.Is(err, client.ErrServerUnavailable) {
+		t.Fatalf("Expected server unavailable error, got: %v", err)
+	}
+
+	// Unreachable servers are handled the same way
+	server.Close()
+	if _, err := bindleClient.GetInvoice(cached.Name()); err != nil {
+		t.Fatalf("Cached invoice should be available while the server is unreachable: %s", err)
+	}
+	if _, err := bindleClient.GetInvoice(uncached.Name()); !errors.Is(err, client.ErrServerUnavailable) {
+		t.Fatalf("Expected server unavailable error, got: %v", err)
+	}
+
+	strictClient := newFakeClient(t, server, client.WithCache(localCache), client.WithOfflineMode(client.OfflineStrict))
+	if _, err := strictClient.GetInvoice(cached.Name()); err != nil {
+		t.Fatalf("Cached invoice should be available in strict mode: %s", err)
+	}
+	if _, err := strictClient.GetParcel(cached.Name(), sha); err != nil {
+		t.Fatalf("Cached parcel should be available in strict mode: %s", err)
+	}
+	if _, err := strictClient.GetInvoice(uncached.Name()); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("Expected cache miss error, got: %v", err)
+	}
+	if _, err := strictClient.GetParcel(uncached.Name(), uncached.Parcel[1].Label.SHA256); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("Expected cache miss error, got: %v", err)
+	}
+	downloadDir := t.TempDir()
+	if err := strictClient.DownloadParcel(cached.Name(), cached.Parcel[0].Label, filepath.Join(downloadDir, "cached.dat")); err != nil {
+		t.Fatalf("Cached parcel should be downloadable in strict mode: %s", err)
+	}
+	uncachedLabel := uncached.Parcel[1].Label
+	for _, opts := range [][]client.DownloadOption{nil, {client.WithParallelChunks(1, 2)}} {
+		if err := strictClient.DownloadParcel(uncached.Name(), uncachedLabel, filepath.Join(downloadDir, "uncached.dat"), opts...); !errors.Is(err, cache.ErrCacheMiss) {
+			t.Fatalf("Expected cache miss error, got: %v", err)
+		}
+	}
+	if _, err := strictClient.GetParcelRange(uncached.Name(), uncachedLabel.SHA256, 0, 1); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("Expected cache miss error, got: %v", err)
+	}
+	if _, err := strictClient.CreateInvoice(uncached); !errors.Is(err, client.ErrOffline) {
+		t.Fatalf("Expected offline error, got: %v", err)
+	}
+}
+
+func TestOfflineHostVerification(t *testing.T) {
+	hostKey, hostPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostorKey, impostorPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := &types.Keyring{Version: "1.0.0", Key: []types.SignatureKey{*hostKey}}
+	signed := newHostSignedInvoice(t, "example.com/signed", hostKey, hostPriv)
+	impostor := newHostSignedInvoice(t, "example.com/impostor", impostorKey, impostorPriv)
+
+	// The server either answers with garbage or with an invoice signed by another host
+	malformed := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/toml")
+		if malformed {
+			w.Write([]byte("this is not [toml"))
+			return
+		}
+		toml.NewEncoder(w).Encode(impostor)
+	}))
+	t.Cleanup(server.Close)
+
+	localCache, err := cache.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := localCache.PutInvoice(&signed); err != nil {
+		t.Fatal(err)
+	}
+	bindleClient, err := client.New(server.URL, nil, client.WithCache(localCache), client.WithOfflineMode(client.OfflineFallback), client.WithHostVerification(trusted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Responses that can't be decoded or fail verification are not outages, so they aren't answered
+	// from the cache
+	if _, err := bindleClient.GetInvoice(impostor.Name()); err == nil || errors.Is(err, client.ErrServerUnavailable) {
+		t.Fatalf("Expected a malformed response to be an error, got: %v", err)
+	}
+	if _, err := bindleClient.QueryInvoices(types.QueryOptions{}); err == nil {
+		t.Fatal("Expected a malformed query response not to be answered from the cache")
+	}
+	malformed = false
+	if _, err := bindleClient.GetInvoice(impostor.Name()); !errors.Is(err, types.ErrMissingSignatureKey) {
+		t.Fatalf("Expected an invoice signed by another host to be refused, got: %v", err)
+	}
+
+	// Invoices answered from the cache are verified too
+	strictClient, err := client.New(server.URL, nil, client.WithCache(localCache), client.WithOfflineMode(client.OfflineStrict), client.WithHostVerification(trusted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, err := strictClient.QueryInvoices(types.QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query should be answered from the cache: %s", err)
+	}
+	if len(matches.Invoices) != 1 || matches.Invoices[0].Name() != signed.Name() {
+		t.Fatalf("Expected only the cached invoice to match, got %d invoices", len(matches.Invoices))
+	}
+	if err := localCache.PutInvoice(&impostor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strictClient.QueryInvoices(types.QueryOptions{}); !errors.Is(err, types.ErrMissingSignatureKey) {
+		t.Fatalf("Expected a cached invoice signed by another host to be refused, got: %v", err)
+	}
+}
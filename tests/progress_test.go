@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/mirror"
+	"github.com/deislabs/go-bindle/types"
+)
+
+// progressRecorder collects progress reports so tests can check them afterwards
+type progressRecorder struct {
+	lock    sync.Mutex
+	reports []client.Progress
+}
+
+func (r *progressRecorder) record(p client.Progress) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.reports = append(r.reports, p)
+}
+
+// final returns the last report of each transfer in the given direction, by parcel SHA
+func (r *progressRecorder) final(t *testing.T, direction client.Direction) map[string]client.Progress {
+	t.Helper()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	final := map[string]client.Progress{}
+	for _, p := range r.reports {
+		if p.Direction != direction {
+			continue
+		}
+		if previous, exists := final[p.SHA]; exists && previous.Done {
+			t.Fatalf("Got a report for %s after it was done: %+v", p.SHA, p)
+		}
+		final[p.SHA] = p
+	}
+	return final
+}
+
+func checkFinalProgress(t *testing.T, p client.Progress, total int64) {
+	t.Helper()
+	if !p.Done || p.Err != nil {
+		t.Fatalf("Expected transfer of %s to be done without error, got %+v", p.SHA, p)
+	}
+	if p.Transferred != total || p.Total != total {
+		t.Fatalf("Expected %d of %d bytes to be transferred, got %d of %d", total, total, p.Transferred, p.Total)
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	server := newFakeServer(t, false)
+	recorder := &progressRecorder{}
+	bindleClient := newFakeClient(t, server, client.WithProgress(recorder.record))
+	inv, data := createLargeParcel(t, bindleClient, 64*1024)
+	sha := inv.Parcel[0].Label.SHA256
+
+	final := recorder.final(t, client.Upload)
+	if len(final) != 1 {
+		t.Fatalf("Expected progress for one upload, got %d", len(final))
+	}
+	checkFinalProgress(t, final[sha], int64(len(data)))
+	if final[sha].BindleID != inv.Name() {
+		t.Fatalf("Expected progress for bindle %s, got %s", inv.Name(), final[sha].BindleID)
+	}
+	if len(recorder.final(t, client.Download)) != 0 {
+		t.Fatal("Uploading should not report downloads")
+	}
+
+	// Failed uploads report the error
+	recorder.reports = nil
+	if err := bindleClient.CreateParcel("enterprise.com/nope/1.0.0", sha, data); err == nil {
+		t.Fatal("Expected upload to a bindle that doesn't exist to fail")
+	}
+	failed := recorder.final(t, client.Upload)[sha]
+	if !failed.Done || failed.Err == nil {
+		t.Fatalf("Expected failed upload to be reported as done with an error, got %+v", failed)
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	server := newFakeServer(t, false)
+	uploader := newFakeClient(t, server)
+	inv, data := createLargeParcel(t, uploader, 64*1024)
+	sha := inv.Parcel[0].Label.SHA256
+	size := int64(len(data))
+
+	recorder := &progressRecorder{}
+	bindleClient := newFakeClient(t, server, client.WithProgress(recorder.record))
+
+	reader, err := bindleClient.GetParcelReader(inv.Name(), sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+	checkFinalProgress(t, recorder.final(t, client.Download)[sha], size)
+
+	recorder.reports = nil
+	path := filepath.Join(t.TempDir(), "parcel.dat")
+	if err := bindleClient.DownloadParcel(inv.Name(), inv.Parcel[0].Label, path); err != nil {
+		t.Fatal(err)
+	}
+	checkFinalProgress(t, recorder.final(t, client.Download)[sha], size)
+
+	recorder.reports = nil
+	path = filepath.Join(t.TempDir(), "parcel.dat")
+	if err := bindleClient.DownloadParcel(inv.Name(), inv.Parcel[0].Label, path, client.WithParallelChunks(8*1024, 4)); err != nil {
+		t.Fatal(err)
+	}
+	checkFinalProgress(t, recorder.final(t, client.Download)[sha], size)
+}
+
+func TestProgressTracker(t *testing.T) {
+	var lastTotal client.AggregateProgress
+	tracker := client.NewProgressTracker(func(parcel client.Progress, total client.AggregateProgress) {
+		lastTotal = total
+	})
+	labels := []types.Label{{SHA256: "abc", Size: 10}, {SHA256: "def", Size: 30}}
+	tracker.Expect(client.Download, labels...)
+
+	if total := tracker.Progress(); total.Parcels != 2 || total.Total != 40 {
+		t.Fatalf("Expected 2 parcels totalling 40 bytes, got %+v", total)
+	}
+
+	tracker.Observe(client.Progress{Direction: client.Download, SHA: "abc", Transferred: 5, Total: 10})
+	tracker.Observe(client.Progress{Direction: client.Download, SHA: "abc", Transferred: 10, Total: 10, Done: true})
+	tracker.Observe(client.Progress{Direction: client.Download, SHA: "def", Transferred: 20, Total: 30, Done: true, Err: client.ErrNotFound})
+	if lastTotal.Transferred != 30 || lastTotal.Completed != 2 || lastTotal.Failed != 1 {
+		t.Fatalf("Unexpected combined progress %+v", lastTotal)
+	}
+
+	// Retrying a failed parcel starts it over
+	tracker.Observe(client.Progress{Direction: client.Download, SHA: "def", Transferred: 0, Total: 30})
+	if lastTotal.Transferred != 10 || lastTotal.Completed != 1 || lastTotal.Failed != 0 {
+		t.Fatalf("Unexpected combined progress after retry %+v", lastTotal)
+	}
+	tracker.Observe(client.Progress{Direction: client.Download, SHA: "def", Transferred: 30, Total: 30, Done: true})
+	expected := client.AggregateProgress{Transferred: 40, Total: 40, Parcels: 2, Completed: 2}
+	if total := tracker.Progress(); total != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, total)
+	}
+}
+
+func TestMirrorProgress(t *testing.T) {
+	source := newFakeClient(t, newFakeServer(t, false))
+	dest := newFakeClient(t, newFakeServer(t, false))
+
+	v2 := load_scaffold_invoice(t, "valid_v2")
+	if _, err := source.CreateInvoice(v2); err != nil {
+		t.Fatal(err)
+	}
+	parcels := map[string][]byte{
+		v2.Parcel[0].Label.SHA256: load_scaffold_parcel_data(t, "valid_v2", "other"),
+		v2.Parcel[1].Label.SHA256: load_scaffold_parcel_data(t, "valid_v2", "parcel"),
+	}
+	var size int64
+	for sha, data := range parcels {
+		if err := source.CreateParcel(v2.Name(), sha, data); err != nil {
+			t.Fatal(err)
+		}
+		size += int64(len(data))
+	}
+
+	tracker := client.NewProgressTracker(nil)
+	m := mirror.New(source, dest, mirror.WithProgress(tracker.Observe))
+	plan, err := m.Plan([]string{v2.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Expect(client.Upload, plan.Bindles[0].Parcels...)
+	if _, err := m.Sync([]string{v2.Name()}); err != nil {
+		t.Fatalf("Unable to sync: %s", err)
+	}
+
+	expected := client.AggregateProgress{Transferred: size, Total: size, Parcels: 2, Completed: 2}
+	if total := tracker.Progress(); total != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, total)
+	}
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/deislabs/go-bindle/bindletest"
+	"github.com/deislabs/go-bindle/client"
+	"github.com/deislabs/go-bindle/keyring"
+	"github.com/deislabs/go-bindle/proxy"
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+)
+
+func TestProxy(t *testing.T) {
+	upstreamServer := newFakeServer(t, false)
+	upstream := newFakeClient(t, upstreamServer)
+
+	inv := load_scaffold_invoice(t, "valid_v2")
+	if _, err := upstream.CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+	parcels := map[string][]byte{
+		inv.Parcel[0].Label.SHA256: load_scaffold_parcel_data(t, "valid_v2", "other"),
+		inv.Parcel[1].Label.SHA256: load_scaffold_parcel_data(t, "valid_v2", "parcel"),
+	}
+	for sha, data := range parcels {
+		if err := upstream.CreateParcel(inv.Name(), sha, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proxyKey, proxyPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(http.StripPrefix("/v1", proxy.New(storage.NewMemoryProvider(), upstream, proxy.WithProxyKey(proxyKey, proxyPriv))))
+	t.Cleanup(proxyServer.Close)
+	consumer, err := client.New(proxyServer.URL+"/v1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The proxy publishes its key so consumers can check the proxy signature
+	proxyKeys, err := consumer.GetHostKeys(context.Background(), types.RoleProxy)
+	if err != nil {
+		t.Fatalf("Unable to get proxy keys: %s", err)
+	}
+	relayed, err := consumer.GetInvoice(inv.Name())
+	if err != nil {
+		t.Fatalf("Unable to get invoice through the proxy: %s", err)
+	}
+	report, err := relayed.VerificationReport(proxyKeys.Key, types.VerificationExhaustive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proxySigned bool
+	for _, result := range report.Results {
+		if result.Signature.Role == types.RoleProxy && result.Status == types.SignatureValid {
+			proxySigned = true
+		}
+	}
+	if !proxySigned {
+		t.Fatal("Relayed invoice should have a valid proxy signature")
+	}
+	if err := relayed.VerifyHostSignature([]types.SignatureKey{*upstreamServer.HostKey}); err != nil {
+		t.Fatalf("Upstream host signature should be preserved: %s", err)
+	}
+
+	// A parcel that is cut off upstream fails verification and isn't stored
+	upstreamServer.SetFaults(bindletest.Faults{TruncateBodies: true})
+	truncatedSHA := inv.Parcel[1].Label.SHA256
+	if _, err := consumer.GetParcel(inv.Name(), truncatedSHA); err == nil {
+		t.Fatal("Expected an error for a parcel that doesn't match its label")
+	}
+	upstreamServer.SetFaults(bindletest.Faults{})
+
+	for sha, data := range parcels {
+		fetched, err := consumer.GetParcel(inv.Name(), sha)
+		if err != nil {
+			t.Fatalf("Unable to get parcel through the proxy: %s", err)
+		}
+		if !bytes.Equal(data, fetched) {
+			t.Fatalf("Got back different data for parcel %s", sha)
+		}
+	}
+
+	// Everything fetched before is now served locally
+	upstreamServer.SetFaults(bindletest.Faults{ErrorStatus: http.StatusServiceUnavailable})
+	if _, err := consumer.GetInvoice(inv.Name()); err != nil {
+		t.Fatalf("Invoice should be served by the proxy while upstream is down: %s", err)
+	}
+	if _, err := consumer.GetParcel(inv.Name(), truncatedSHA); err != nil {
+		t.Fatalf("Parcel should be served by the proxy while upstream is down: %s", err)
+	}
+	upstreamServer.SetFaults(bindletest.Faults{})
+
+	// Writes and queries go to upstream
+	created := load_scaffold_invoice(t, "valid_v1")
+	if _, err := consumer.CreateInvoice(created); err != nil {
+		t.Fatalf("Unable to create invoice through the proxy: %s", err)
+	}
+	if _, err := upstream.GetInvoice(created.Name()); err != nil {
+		t.Fatalf("Invoice should have been created upstream: %s", err)
+	}
+	query := created.Bindle.Name
+	matches, err := consumer.QueryInvoices(types.QueryOptions{Query: &query})
+	if err != nil {
+		t.Fatalf("Unable to query through the proxy: %s", err)
+	}
+	if matches.Total != 2 {
+		t.Fatalf("Expected 2 matches, got %d", matches.Total)
+	}
+}
+
+func TestProxyUpstreamYank(t *testing.T) {
+	upstreamServer := newFakeServer(t, false)
+	upstream := newFakeClient(t, upstreamServer)
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := upstream.CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	newConsumer := func(opts ...proxy.Option) *client.Client {
+		proxyServer := httptest.NewServer(http.StripPrefix("/v1", proxy.New(storage.NewMemoryProvider(), upstream, opts...)))
+		t.Cleanup(proxyServer.Close)
+		consumer, err := client.New(proxyServer.URL+"/v1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := consumer.GetInvoice(inv.Name()); err != nil {
+			t.Fatalf("Unable to get invoice through the proxy: %s", err)
+		}
+		return consumer
+	}
+	alwaysChecked := newConsumer(proxy.WithYankCheckInterval(0))
+	rarelyChecked := newConsumer(proxy.WithYankCheckInterval(time.Hour))
+
+	if err := upstream.YankInvoice(inv.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The yank reaches consumers once the proxy checks upstream again
+	if _, err := alwaysChecked.GetInvoice(inv.Name()); err == nil {
+		t.Fatal("Expected an invoice yanked upstream to be refused")
+	}
+	yanked, err := alwaysChecked.GetYankedInvoice(inv.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yanked.Yanked == nil || !*yanked.Yanked {
+		t.Fatal("Invoice yanked upstream should be marked as yanked")
+	}
+
+	// Until then, the stored invoice is served as is
+	if _, err := rarelyChecked.GetInvoice(inv.Name()); err != nil {
+		t.Fatalf("Expected the stored invoice to be served until the next yank check: %s", err)
+	}
+}
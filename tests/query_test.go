@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/deislabs/go-bindle/types"
+)
+
+func TestQueryString(t *testing.T) {
+	query := "example.com/foo bar&baz"
+	version := ">=1.0.0"
+	offset := uint64(10)
+	limit := uint8(5)
+	strict := true
+	yanked := false
+	opts := types.QueryOptions{
+		Query:   &query,
+		Version: &version,
+		Offset:  &offset,
+		Limit:   &limit,
+		Strict:  &strict,
+		Yanked:  &yanked,
+	}
+
+	expected := "?q=example.com%2Ffoo+bar%26baz&v=%3E%3D1.0.0&o=10&l=5&strict=true&yanked=false"
+	if qs := opts.QueryString(); qs != expected {
+		t.Fatalf("Expected query string %s, got %s", expected, qs)
+	}
+
+	// The server should get back exactly the options that were set
+	values, err := url.ParseQuery(opts.QueryString()[1:])
+	if err != nil {
+		t.Fatalf("Unable to parse query string: %s", err)
+	}
+	if values.Get("q") != query || values.Get("v") != version {
+		t.Fatalf("Query string does not round trip, got q=%q v=%q", values.Get("q"), values.Get("v"))
+	}
+
+	if qs := (&types.QueryOptions{}).QueryString(); qs != "?" {
+		t.Fatalf("Expected an empty query string, got %s", qs)
+	}
+}
@@ -0,0 +1,254 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/deislabs/go-bindle/keyring"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+func newTestInvoice(authors ...string) *types.Invoice {
+	return &types.Invoice{
+		BindleVersion: "1.0.0",
+		Bindle: types.BindleSpec{
+			Name:    "importantproj",
+			Version: "0.1.0",
+			Authors: authors,
+		},
+		Parcel: []types.Parcel{
+			types.NewParcel("importantfile", "application/important", []byte("something very important")),
+		},
+	}
+}
+
+func TestDetachedSignature(t *testing.T) {
+	sigKey, privKey, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice := newTestInvoice(testAuthor)
+	detached, err := invoice.GenerateDetachedSignature(testAuthor, types.RoleCreator, sigKey, privKey)
+	if err != nil {
+		t.Fatalf("Unable to generate detached signature: %s", err)
+	}
+	if len(invoice.Signature) != 0 {
+		t.Fatal("Generating a detached signature should not modify the invoice")
+	}
+
+	// Make sure the signature survives a round trip through TOML
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(detached); err != nil {
+		t.Fatal(err)
+	}
+	var decoded types.DetachedSignature
+	if err := toml.NewDecoder(&buf).Strict(true).Decode(&decoded); err != nil {
+		t.Fatalf("Unable to decode detached signature: %s", err)
+	}
+
+	if err := invoice.VerifyDetachedSignature(&decoded, []types.SignatureKey{*sigKey}, types.VerificationExhaustive); err != nil {
+		t.Fatalf("Detached signature should be valid: %s", err)
+	}
+
+	// A detached signature should not apply to an invoice with different parcels
+	other := newTestInvoice(testAuthor)
+	other.Parcel = append(other.Parcel, types.NewParcel("otherfile", "text/plain", []byte("other")))
+	if err := other.VerifyDetachedSignature(&decoded, []types.SignatureKey{*sigKey}, types.VerificationExhaustive); !errors.Is(err, types.ErrDetachedSignatureMismatch) {
+		t.Fatalf("Expected detached signature mismatch error, got: %v", err)
+	}
+
+	// Merging twice should only add the signature once
+	for i := 0; i < 2; i++ {
+		if err := invoice.MergeDetachedSignature(&decoded); err != nil {
+			t.Fatalf("Unable to merge detached signature: %s", err)
+		}
+	}
+	if len(invoice.Signature) != 1 {
+		t.Fatalf("Expected 1 signature after merging, got %d", len(invoice.Signature))
+	}
+	if err := invoice.VerifySignatures([]types.SignatureKey{*sigKey}, types.VerificationExhaustive); err != nil {
+		t.Fatalf("Merged signature should be valid: %s", err)
+	}
+}
+
+func TestApprove(t *testing.T) {
+	creatorKey, creatorPriv, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approverKey, approverPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleApprover)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice := newTestInvoice(testAuthor)
+	if err := invoice.Approve(testAuthor2, approverKey, approverPriv, []types.SignatureKey{*creatorKey}); !errors.Is(err, types.ErrMissingCreatorSignature) {
+		t.Fatalf("Expected missing creator signature error, got: %v", err)
+	}
+
+	if err := invoice.GenerateSignature(testAuthor, types.RoleCreator, creatorKey, creatorPriv); err != nil {
+		t.Fatal(err)
+	}
+
+	// Approving without the creator's key should fail
+	if err := invoice.Approve(testAuthor2, approverKey, approverPriv, []types.SignatureKey{*approverKey}); err == nil {
+		t.Fatal("Should not be able to approve without verifying the creator signature")
+	}
+
+	// The approver is not an author of the bindle, which is fine for approvals
+	if err := invoice.Approve(testAuthor2, approverKey, approverPriv, []types.SignatureKey{*creatorKey}); err != nil {
+		t.Fatalf("Unable to approve invoice: %s", err)
+	}
+	if len(invoice.Signature) != 2 || invoice.Signature[1].Role != types.RoleApprover {
+		t.Fatalf("Expected an approver signature to be added, got %v", invoice.Signature)
+	}
+
+	if err := invoice.VerifySignatures([]types.SignatureKey{*creatorKey, *approverKey}, types.VerificationExhaustive); err != nil {
+		t.Fatalf("Approved invoice should be valid: %s", err)
+	}
+
+	// Creators still have to be authors
+	otherCreatorKey, otherCreatorPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := invoice.GenerateSignature(testAuthor2, types.RoleCreator, otherCreatorKey, otherCreatorPriv); !errors.Is(err, types.ErrAuthorNotExist) {
+		t.Fatalf("Expected author not exist error, got: %v", err)
+	}
+}
+
+func TestVerificationReport(t *testing.T) {
+	creatorKey, creatorPriv, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approverKey, approverPriv, err := keyring.GenerateSignatureKey(testAuthor2, types.RoleApprover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyKey, proxyPriv, err := keyring.GenerateSignatureKey("proxy.example.com", types.RoleProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice := newTestInvoice(testAuthor)
+	if err := invoice.GenerateSignature(testAuthor, types.RoleCreator, creatorKey, creatorPriv); err != nil {
+		t.Fatal(err)
+	}
+	if err := invoice.GenerateSignature(testAuthor2, types.RoleApprover, approverKey, approverPriv); err != nil {
+		t.Fatal(err)
+	}
+	if err := invoice.GenerateSignature("proxy.example.com", types.RoleProxy, proxyKey, proxyPriv); err != nil {
+		t.Fatal(err)
+	}
+	// Tamper with the proxy signature
+	invoice.Signature[2].Role = types.RoleHost
+
+	// The approver is only trusted as a creator and the proxy key isn't trusted at all
+	approverAsCreator := *approverKey
+	approverAsCreator.Roles = []string{types.RoleCreator}
+	proxyAsHost := *proxyKey
+	proxyAsHost.Roles = []string{types.RoleHost}
+
+	report, err := invoice.VerificationReport([]types.SignatureKey{*creatorKey, approverAsCreator, proxyAsHost}, types.VerificationExhaustive)
+	if err != nil {
+		t.Fatalf("Unable to generate report: %s", err)
+	}
+	if report.Passed {
+		t.Fatal("Report should not have passed")
+	}
+
+	expected := []types.SignatureStatus{types.SignatureValid, types.SignatureRoleMismatch, types.SignatureBad}
+	for i, status := range expected {
+		if report.Results[i].Status != status {
+			t.Errorf("Expected signature %d to be %s, got %s", i, status, report.Results[i].Status)
+		}
+	}
+	if report.Results[0].Key == nil || report.Results[0].Key.Key != creatorKey.Key {
+		t.Error("Valid signature should reference the matching keyring entry")
+	}
+	if len(report.Failed()) != 2 {
+		t.Errorf("Expected 2 failed signatures, got %d", len(report.Failed()))
+	}
+	if !errors.Is(report.Err(), types.ErrSignatureKeyRoleMismatch) {
+		t.Errorf("Expected the first failure to be a role mismatch, got: %v", report.Err())
+	}
+
+	report, err = invoice.VerificationReport([]types.SignatureKey{*approverKey}, types.VerificationExhaustive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Results[0].Status != types.SignatureUnknownKey {
+		t.Errorf("Expected creator signature to have an unknown key, got %s", report.Results[0].Status)
+	}
+
+	// The signing time isn't covered by the signature, so anyone can rewrite it without invalidating
+	// the signature. That's why the report doesn't check signature age
+	invoice.Signature = invoice.Signature[:1]
+	invoice.Signature[0].At -= 3600
+	report, err = invoice.VerificationReport([]types.SignatureKey{*creatorKey}, types.VerificationExhaustive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Results[0].Status != types.SignatureValid {
+		t.Errorf("Expected a rewritten signing time not to affect verification, got %s", report.Results[0].Status)
+	}
+}
+
+func TestMultipleKeysPerLabel(t *testing.T) {
+	laptopKey, laptopPriv, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desktopKey, desktopPriv, err := keyring.GenerateSignatureKey(testAuthor, types.RoleCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice := newTestInvoice(testAuthor)
+	if err := invoice.GenerateSignature(testAuthor, types.RoleCreator, laptopKey, laptopPriv); err != nil {
+		t.Fatal(err)
+	}
+	if err := invoice.GenerateSignature(testAuthor, types.RoleCreator, desktopKey, desktopPriv); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both keys for the same author must be usable, regardless of their order in the keyring
+	for _, keys := range [][]types.SignatureKey{{*laptopKey, *desktopKey}, {*desktopKey, *laptopKey}} {
+		report, err := invoice.VerificationReport(keys, types.VerificationExhaustive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Passed {
+			t.Fatalf("Signatures from both keys should be valid: %s", report.Err())
+		}
+		if report.Results[0].Key.Key != laptopKey.Key || report.Results[1].Key.Key != desktopKey.Key {
+			t.Fatal("Signatures should be matched to the exact key that made them")
+		}
+	}
+
+	// Signatures from a rotated out key are unknown once the key is no longer trusted
+	report, err := invoice.VerificationReport([]types.SignatureKey{*desktopKey}, types.VerificationExhaustive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Results[0].Status != types.SignatureUnknownKey || report.Results[1].Status != types.SignatureValid {
+		t.Fatalf("Expected [unknown key, valid], got [%s, %s]", report.Results[0].Status, report.Results[1].Status)
+	}
+
+	// Older signatures may not include the public key, in which case every key for the signer is
+	// tried
+	for i := range invoice.Signature {
+		invoice.Signature[i].Key = ""
+	}
+	if err := invoice.VerifySignatures([]types.SignatureKey{*desktopKey, *laptopKey}, types.VerificationExhaustive); err != nil {
+		t.Fatalf("Signatures without an embedded key should be valid: %s", err)
+	}
+	if err := invoice.VerifySignatures([]types.SignatureKey{*desktopKey}, types.VerificationExhaustive); !errors.Is(err, types.ErrInvalidSignature) {
+		t.Fatalf("Expected invalid signature error, got: %v", err)
+	}
+}
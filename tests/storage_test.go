@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deislabs/go-bindle/server"
+	"github.com/deislabs/go-bindle/storage"
+	"github.com/deislabs/go-bindle/types"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestStorageProviders(t *testing.T) {
+	fileProvider, err := storage.NewFileProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := map[string]storage.Provider{
+		"memory": storage.NewMemoryProvider(),
+		"file":   fileProvider,
+	}
+	for name, provider := range providers {
+		provider := provider
+		t.Run(name, func(t *testing.T) {
+			checkStorageProvider(t, provider)
+		})
+	}
+}
+
+func checkStorageProvider(t *testing.T, provider storage.Provider) {
+	inv := load_scaffold_invoice(t, "valid_v2")
+	missing, err := provider.CreateInvoice(&inv)
+	if err != nil {
+		t.Fatalf("Unable to create invoice: %s", err)
+	}
+	if len(missing) != 2 {
+		t.Fatalf("Expected 2 missing parcels, got %d", len(missing))
+	}
+	if _, err := provider.CreateInvoice(&inv); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Fatalf("Expected already exists error, got: %v", err)
+	}
+
+	sha := inv.Parcel[0].Label.SHA256
+	if err := provider.CreateParcel(inv.Name(), sha, bytes.NewReader([]byte("wrong data"))); !errors.Is(err, storage.ErrInvalidParcel) {
+		t.Fatalf("Expected invalid parcel error, got: %v", err)
+	}
+	// Oversized data is refused after reading just past the size in the label
+	endless := &endlessReader{}
+	if err := provider.CreateParcel(inv.Name(), sha, endless); !errors.Is(err, storage.ErrInvalidParcel) {
+		t.Fatalf("Expected invalid parcel error, got: %v", err)
+	}
+	if limit := int64(inv.Parcel[0].Label.Size) + 1; endless.read > limit {
+		t.Fatalf("Expected at most %d bytes of oversized data to be read, got %d", limit, endless.read)
+	}
+	data := load_scaffold_parcel_data(t, "valid_v2", "other")
+	if err := provider.CreateParcel(inv.Name(), sha, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Unable to create parcel: %s", err)
+	}
+	if err := provider.CreateParcel(inv.Name(), sha, bytes.NewReader(data)); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Fatalf("Expected already exists error, got: %v", err)
+	}
+
+	missing, err = provider.MissingParcels(inv.Name())
+	if err != nil {
+		t.Fatalf("Unable to get missing parcels: %s", err)
+	}
+	if len(missing) != 1 || missing[0].SHA256 != inv.Parcel[1].Label.SHA256 {
+		t.Fatalf("Expected only the second parcel to be missing, got %v", missing)
+	}
+
+	reader, err := provider.GetParcel(inv.Name(), sha)
+	if err != nil {
+		t.Fatalf("Unable to get parcel: %s", err)
+	}
+	stored, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, stored) {
+		t.Fatalf("Got back different parcel data\nExpected: %s\nGot: %s", data, stored)
+	}
+	if _, err := provider.GetParcel(inv.Name(), inv.Parcel[1].Label.SHA256); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Expected not found error, got: %v", err)
+	}
+	if _, err := provider.GetParcel("nonexistent/1.0.0", sha); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Expected not found error, got: %v", err)
+	}
+
+	if err := provider.YankInvoice(inv.Name()); err != nil {
+		t.Fatalf("Unable to yank invoice: %s", err)
+	}
+	if _, err := provider.GetInvoice(inv.Name()); !errors.Is(err, storage.ErrYanked) {
+		t.Fatalf("Expected yanked error, got: %v", err)
+	}
+	yanked, err := provider.GetYankedInvoice(inv.Name())
+	if err != nil {
+		t.Fatalf("Unable to get yanked invoice: %s", err)
+	}
+	if yanked.Yanked == nil || !*yanked.Yanked {
+		t.Fatal("Invoice should be marked as yanked")
+	}
+
+	invoices, err := provider.(storage.Lister).ListInvoices()
+	if err != nil {
+		t.Fatalf("Unable to list invoices: %s", err)
+	}
+	if len(invoices) != 1 || invoices[0].Name() != inv.Name() {
+		t.Fatalf("Expected to list %s, got %d invoices", inv.Name(), len(invoices))
+	}
+}
+
+// endlessReader is a never ending stream of data that counts how much was read from it
+type endlessReader struct {
+	read int64
+}
+
+func (e *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	e.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestFileProviderLayout(t *testing.T) {
+	root := t.TempDir()
+	provider, err := storage.NewFileProvider(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := provider.CreateInvoice(&inv); err != nil {
+		t.Fatal(err)
+	}
+	sha := inv.Parcel[0].Label.SHA256
+	if err := provider.CreateParcel(inv.Name(), sha, bytes.NewReader(load_scaffold_parcel_data(t, "valid_v1", "parcel"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files must be in the documented layout
+	idSum := sha256.Sum256([]byte(inv.Name()))
+	for _, path := range []string{
+		filepath.Join(root, "invoices", hex.EncodeToString(idSum[:]), "invoice.toml"),
+		filepath.Join(root, "parcels", sha, "label.toml"),
+		filepath.Join(root, "parcels", sha, "parcel.dat"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to exist: %s", path, err)
+		}
+	}
+	var label types.Label
+	raw, err := ioutil.ReadFile(filepath.Join(root, "parcels", sha, "label.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := toml.Unmarshal(raw, &label); err != nil {
+		t.Fatalf("Unable to parse stored label: %s", err)
+	}
+	if !reflect.DeepEqual(label, inv.Parcel[0].Label) {
+		t.Fatalf("Stored label doesn't match the invoice\nExpected: %v\nGot: %v", inv.Parcel[0].Label, label)
+	}
+
+	// A new provider for the same directory sees the same data
+	reopened, err := storage.NewFileProvider(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := reopened.GetInvoice(inv.Name())
+	if err != nil {
+		t.Fatalf("Unable to get invoice: %s", err)
+	}
+	if stored.Bindle.Name != inv.Bindle.Name || len(stored.Parcel) != len(inv.Parcel) {
+		t.Fatalf("Got back a different invoice: %v", stored)
+	}
+	missing, err := reopened.MissingParcels(inv.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("Expected no missing parcels, got %d", len(missing))
+	}
+}
+
+func TestStorageInvalidSHA(t *testing.T) {
+	fileProvider, err := storage.NewFileProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("parcel data")
+	sum := sha256.Sum256(data)
+	sha := hex.EncodeToString(sum[:])
+
+	providers := map[string]storage.Provider{
+		"memory": storage.NewMemoryProvider(),
+		"file":   fileProvider,
+	}
+	for name, provider := range providers {
+		for _, invalid := range []string{"", "..", "x/" + sha, strings.ToUpper(sha), sha[1:]} {
+			inv := types.Invoice{
+				BindleVersion: "1.0.0",
+				Bindle:        types.BindleSpec{Name: "example.com/invalid", Version: "1.0.0"},
+				Parcel: []types.Parcel{{Label: types.Label{
+					Name:      "parcel",
+					SHA256:    invalid,
+					MediaType: "text/plain",
+					Size:      uint64(len(data)),
+				}}},
+			}
+			if _, err := provider.CreateInvoice(&inv); !errors.Is(err, storage.ErrInvalidSHA) {
+				t.Errorf("%s: expected an invoice with parcel SHA %q to be refused, got: %v", name, invalid, err)
+			}
+			if _, err := provider.GetInvoice(inv.Name()); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("%s: expected the refused invoice not to be stored, got: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestServerReindex(t *testing.T) {
+	root := t.TempDir()
+	provider, err := storage.NewFileProvider(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := load_scaffold_invoice(t, "valid_v1")
+	if _, err := provider.CreateInvoice(&inv); err != nil {
+		t.Fatal(err)
+	}
+
+	// A server started on existing data can only find it after reindexing
+	search := server.NewMemorySearch()
+	bindleServer := server.New(provider, server.WithSearch(search))
+	if err := bindleServer.Reindex(); err != nil {
+		t.Fatalf("Unable to reindex: %s", err)
+	}
+	matches, err := search.Query(types.QueryOptions{Query: &inv.Bindle.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches.Total != 1 {
+		t.Fatalf("Expected 1 match after reindexing, got %d", matches.Total)
+	}
+}
@@ -0,0 +1,229 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MarshalCanonical encodes the invoice as TOML in a canonical form: fields are always written in
+// the same order (the order of the spec), map keys such as annotations are sorted, unset optional
+// fields are omitted and whitespace is normalized. The same invoice always produces the same bytes,
+// regardless of the TOML library version, so the output is suitable for storing, diffing and
+// content addressing invoices
+func (i *Invoice) MarshalCanonical() ([]byte, error) {
+	var w canonicalWriter
+
+	w.keyValue("bindleVersion", i.BindleVersion)
+	if i.Yanked != nil {
+		w.keyValue("yanked", *i.Yanked)
+	}
+
+	w.table("bindle")
+	w.keyValue("name", i.Bindle.Name)
+	w.keyValue("version", i.Bindle.Version)
+	if i.Bindle.Description != nil {
+		w.keyValue("description", *i.Bindle.Description)
+	}
+	if len(i.Bindle.Authors) > 0 {
+		w.keyValue("authors", i.Bindle.Authors)
+	}
+
+	if len(i.Annotations) > 0 {
+		w.table("annotations")
+		w.stringMap(i.Annotations)
+	}
+
+	for _, s := range i.Signature {
+		w.arrayTable("signature")
+		w.keyValue("by", s.By)
+		w.keyValue("signature", s.Signature)
+		w.keyValue("key", s.Key)
+		w.keyValue("role", s.Role)
+		w.keyValue("at", s.At)
+	}
+
+	for _, p := range i.Parcel {
+		w.arrayTable("parcel")
+		w.table("parcel", "label")
+		w.keyValue("sha256", p.Label.SHA256)
+		w.keyValue("mediaType", p.Label.MediaType)
+		w.keyValue("name", p.Label.Name)
+		w.keyValue("size", p.Label.Size)
+		if len(p.Label.Annotations) > 0 {
+			w.table("parcel", "label", "annotations")
+			w.stringMap(p.Label.Annotations)
+		}
+		for _, name := range sortedKeys(p.Label.Feature) {
+			w.table("parcel", "label", "feature", name)
+			w.stringMap(p.Label.Feature[name])
+		}
+		if p.Conditions != nil {
+			w.table("parcel", "conditions")
+			if len(p.Conditions.MemberOf) > 0 {
+				w.keyValue("memberOf", p.Conditions.MemberOf)
+			}
+			if len(p.Conditions.Requires) > 0 {
+				w.keyValue("requires", p.Conditions.Requires)
+			}
+		}
+	}
+
+	for _, g := range i.Group {
+		w.arrayTable("group")
+		w.keyValue("name", g.Name)
+		if g.Required != nil {
+			w.keyValue("required", *g.Required)
+		}
+		if g.SatisfiedBy != nil {
+			w.keyValue("satisfiedBy", *g.SatisfiedBy)
+		}
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// CanonicalDigest returns the hex encoded SHA256 digest of the canonical TOML encoding of the
+// invoice (see `MarshalCanonical`), which can be used to content address the invoice
+func (i *Invoice) CanonicalDigest() (string, error) {
+	data, err := i.MarshalCanonical()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalWriter writes TOML with a single blank line before every table header and no other
+// blank lines
+type canonicalWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (w *canonicalWriter) table(keys ...string) {
+	w.header("[", "]", keys)
+}
+
+func (w *canonicalWriter) arrayTable(keys ...string) {
+	w.header("[[", "]]", keys)
+}
+
+func (w *canonicalWriter) header(open, close string, keys []string) {
+	if w.buf.Len() > 0 {
+		w.buf.WriteString("\n")
+	}
+
+	quoted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		quoted = append(quoted, tomlKey(k))
+	}
+	fmt.Fprintf(&w.buf, "%s%s%s\n", open, strings.Join(quoted, "."), close)
+}
+
+func (w *canonicalWriter) keyValue(key string, value interface{}) {
+	var encoded string
+	switch v := value.(type) {
+	case string:
+		encoded = tomlString(v)
+	case bool:
+		encoded = strconv.FormatBool(v)
+	case int64:
+		encoded = strconv.FormatInt(v, 10)
+	case uint64:
+		// TOML integers are 64 bit signed values
+		if v > 1<<63-1 {
+			w.err = fmt.Errorf("value of %s is too large for TOML: %d", key, v)
+			return
+		}
+		encoded = strconv.FormatUint(v, 10)
+	case []string:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, tomlString(item))
+		}
+		encoded = "[" + strings.Join(items, ", ") + "]"
+	default:
+		w.err = fmt.Errorf("unsupported canonical TOML value type %T", value)
+		return
+	}
+
+	fmt.Fprintf(&w.buf, "%s = %s\n", tomlKey(key), encoded)
+}
+
+func (w *canonicalWriter) stringMap(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		w.keyValue(k, m[k])
+	}
+}
+
+func sortedKeys(m map[string]map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// tomlKey returns the key as a bare key if possible, otherwise as a quoted key
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+
+	for _, r := range key {
+		isBare := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-'
+		if !isBare {
+			return tomlString(key)
+		}
+	}
+
+	return key
+}
+
+// tomlString encodes the string as a TOML basic string
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
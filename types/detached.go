@@ -0,0 +1,113 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var ErrDetachedSignatureMismatch = errors.New("detached signature does not match the invoice")
+
+// DetachedSignature is a set of invoice signatures kept separately from the invoice itself. Adding
+// a signature to an invoice changes its content, so detached signatures allow signatures to be
+// produced (for example offline) and distributed without touching the invoice. The invoice is
+// referenced by its bindle ID and the digest of its parcels, which is everything the signatures
+// cover. Detached signatures can be verified alongside the invoice or merged into it when needed
+type DetachedSignature struct {
+	// The ID of the signed bindle (e.g. example.com/foo/1.0.0)
+	Invoice string `toml:"invoice" json:"invoice" yaml:"invoice"`
+	// The digest of the signed invoice's parcels, see `Invoice.ParcelDigest`
+	ParcelDigest string      `toml:"parcelDigest" json:"parcelDigest" yaml:"parcelDigest"`
+	Signature    []Signature `toml:"signature" json:"signature" yaml:"signature"`
+}
+
+// ParcelDigest returns the hex encoded SHA256 digest of the parcel SHAs of the invoice (in the
+// order they appear in the invoice, joined by newlines), which identifies the set of parcels the
+// invoice's signatures cover
+func (i *Invoice) ParcelDigest() string {
+	shas := make([]string, 0, len(i.Parcel))
+	for _, p := range i.Parcel {
+		shas = append(shas, p.Label.SHA256)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(shas, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateDetachedSignature generates a signature for the provided role and author the same way
+// as `GenerateSignature`, but returns it as a detached signature instead of adding it to the
+// invoice. Additional signatures can be added to the same document with `AddDetachedSignature`
+func (i *Invoice) GenerateDetachedSignature(author, role string, sigKey *SignatureKey, privKey []byte) (*DetachedSignature, error) {
+	detached := &DetachedSignature{
+		Invoice:      i.Name(),
+		ParcelDigest: i.ParcelDigest(),
+		Signature:    []Signature{},
+	}
+
+	if err := i.AddDetachedSignature(detached, author, role, sigKey, privKey); err != nil {
+		return nil, err
+	}
+
+	return detached, nil
+}
+
+// AddDetachedSignature generates a signature for the provided role and author and adds it to an
+// existing detached signature for this invoice
+func (i *Invoice) AddDetachedSignature(detached *DetachedSignature, author, role string, sigKey *SignatureKey, privKey []byte) error {
+	if !detached.Matches(i) {
+		return ErrDetachedSignatureMismatch
+	}
+
+	signature, err := i.newSignature(author, role, sigKey, privKey)
+	if err != nil {
+		return err
+	}
+
+	detached.Signature = append(detached.Signature, *signature)
+
+	return nil
+}
+
+// VerifyDetachedSignature verifies the signatures of a detached signature against this invoice,
+// following the same rules as `VerifySignatures`. Signatures already on the invoice are not
+// checked
+func (i *Invoice) VerifyDetachedSignature(detached *DetachedSignature, sigKeys []SignatureKey, strategy VerificationStrategy) error {
+	if !detached.Matches(i) {
+		return ErrDetachedSignatureMismatch
+	}
+
+	signed := *i
+	signed.Signature = detached.Signature
+
+	return signed.VerifySignatures(sigKeys, strategy)
+}
+
+// MergeDetachedSignature adds the signatures of a detached signature to the invoice's signature
+// list, skipping any signatures the invoice already has. The signatures are not verified, so
+// callers will generally want to call `VerifyDetachedSignature` first
+func (i *Invoice) MergeDetachedSignature(detached *DetachedSignature) error {
+	if !detached.Matches(i) {
+		return ErrDetachedSignatureMismatch
+	}
+
+	existing := map[string]bool{}
+	for _, s := range i.Signature {
+		existing[s.Signature] = true
+	}
+
+	for _, s := range detached.Signature {
+		if existing[s.Signature] {
+			continue
+		}
+		i.Signature = append(i.Signature, s)
+		existing[s.Signature] = true
+	}
+
+	return nil
+}
+
+// Matches returns true if the detached signature references the given invoice
+func (d *DetachedSignature) Matches(inv *Invoice) bool {
+	return d.Invoice == inv.Name() && d.ParcelDigest == inv.ParcelDigest()
+}
@@ -0,0 +1,138 @@
+package types
+
+import "errors"
+
+var ErrKeyNotFound = errors.New("key not found in keyring")
+
+// List returns all keys in the keyring that include at least one of the given roles. If no roles
+// are given, all keys are returned
+func (k *Keyring) List(roles ...string) []SignatureKey {
+	if len(roles) == 0 {
+		return append([]SignatureKey{}, k.Key...)
+	}
+
+	keys := []SignatureKey{}
+	for _, key := range k.Key {
+		for _, role := range roles {
+			if key.IncludesRole(role) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+
+	return keys
+}
+
+// FindByLabel returns all keys in the keyring with the given label. A label can have more than one
+// key (for example, when an author uses multiple machines or has rotated their key)
+func (k *Keyring) FindByLabel(label string) []SignatureKey {
+	keys := []SignatureKey{}
+	for _, key := range k.Key {
+		if key.Label == label {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// FindByKey returns the entry for the given base64 encoded public key, or nil if it is not in the
+// keyring
+func (k *Keyring) FindByKey(key string) *SignatureKey {
+	for i := range k.Key {
+		if k.Key[i].Key == key {
+			return &k.Key[i]
+		}
+	}
+
+	return nil
+}
+
+// Add adds the key to the keyring. Keys are unique by public key, so if the key already exists any
+// new roles are merged into the existing entry instead. Returns true if a new entry was added
+func (k *Keyring) Add(key SignatureKey) bool {
+	if existing := k.FindByKey(key.Key); existing != nil {
+		existing.mergeRoles(key.Roles)
+		return false
+	}
+
+	// Copy the roles so merging roles later doesn't modify the caller's key
+	key.Roles = append([]string{}, key.Roles...)
+	k.Key = append(k.Key, key)
+	return true
+}
+
+// Remove removes the entry for the given base64 encoded public key. Returns false if the key was
+// not in the keyring
+func (k *Keyring) Remove(key string) bool {
+	for i := range k.Key {
+		if k.Key[i].Key == key {
+			k.Key = append(k.Key[:i], k.Key[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoveLabel removes all keys with the given label and returns the number of keys removed
+func (k *Keyring) RemoveLabel(label string) int {
+	kept := k.Key[:0]
+	for _, key := range k.Key {
+		if key.Label != label {
+			kept = append(kept, key)
+		}
+	}
+
+	removed := len(k.Key) - len(kept)
+	k.Key = kept
+	return removed
+}
+
+// Rotate replaces the entry for the given base64 encoded public key with newKey, keeping its
+// position in the keyring. Returns `ErrKeyNotFound` if oldKey is not in the keyring
+func (k *Keyring) Rotate(oldKey string, newKey SignatureKey) error {
+	for i := range k.Key {
+		if k.Key[i].Key == oldKey {
+			k.Key[i] = newKey
+			// The new key may have already been trusted separately
+			k.Dedupe()
+			return nil
+		}
+	}
+
+	return ErrKeyNotFound
+}
+
+// Merge adds all of the keys from other into this keyring, following the same rules as `Add`.
+// Returns the number of new entries added
+func (k *Keyring) Merge(other *Keyring) int {
+	added := 0
+	for _, key := range other.Key {
+		if k.Add(key) {
+			added++
+		}
+	}
+
+	return added
+}
+
+// Dedupe removes any duplicate entries for the same public key, merging their roles into the first
+// entry. Returns the number of entries removed
+func (k *Keyring) Dedupe() int {
+	deduped := Keyring{Version: k.Version, Key: []SignatureKey{}}
+	deduped.Merge(k)
+
+	removed := len(k.Key) - len(deduped.Key)
+	k.Key = deduped.Key
+	return removed
+}
+
+func (s *SignatureKey) mergeRoles(roles []string) {
+	for _, role := range roles {
+		if !s.IncludesRole(role) {
+			s.Roles = append(s.Roles, role)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package types
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultQueryLimit is the number of results returned by a query that doesn't set a limit
+const DefaultQueryLimit = 50
+
+// Search runs the query against the given invoices locally and returns the matching page of
+// results, sorted by ID. Non-strict queries match invoices whose name contains the query and
+// strict queries only match the exact name. Versions must match exactly. This mirrors what a
+// simple Bindle server search engine does, for places that don't have a server to ask
+func (q *QueryOptions) Search(invoices []Invoice) *Matches {
+	matches := Matches{Limit: DefaultQueryLimit, Invoices: []Invoice{}}
+	if q.Query != nil {
+		matches.Query = *q.Query
+	}
+	if q.Strict != nil {
+		matches.Strict = *q.Strict
+	}
+	if q.Yanked != nil {
+		matches.Yanked = *q.Yanked
+	}
+	if q.Offset != nil {
+		matches.Offset = *q.Offset
+	}
+	if q.Limit != nil {
+		matches.Limit = *q.Limit
+	}
+
+	var found []Invoice
+	for _, inv := range invoices {
+		if inv.Yanked != nil && *inv.Yanked && !matches.Yanked {
+			continue
+		}
+		if matches.Strict && matches.Query != "" && inv.Bindle.Name != matches.Query {
+			continue
+		}
+		if !matches.Strict && !strings.Contains(inv.Bindle.Name, matches.Query) {
+			continue
+		}
+		if q.Version != nil && *q.Version != "" && inv.Bindle.Version != *q.Version {
+			continue
+		}
+		found = append(found, inv)
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Name() < found[j].Name() })
+
+	matches.Total = uint64(len(found))
+	if matches.Offset < matches.Total {
+		end := matches.Offset + uint64(matches.Limit)
+		if end > matches.Total {
+			end = matches.Total
+		}
+		matches.Invoices = found[matches.Offset:end]
+		matches.More = end < matches.Total
+	}
+	return &matches
+}
@@ -0,0 +1,192 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SignatureStatus is the outcome of verifying a single signature on an invoice
+type SignatureStatus int
+
+const (
+	// SignatureValid means the signature was made by a trusted key for its role
+	SignatureValid SignatureStatus = iota
+	// SignatureUnknownKey means there is no trusted key for the signer
+	SignatureUnknownKey
+	// SignatureRoleMismatch means the signer's key is not trusted for the signature's role
+	SignatureRoleMismatch
+	// SignatureBad means the signature does not match the invoice or the signer's key
+	SignatureBad
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case SignatureValid:
+		return "valid"
+	case SignatureUnknownKey:
+		return "unknown key"
+	case SignatureRoleMismatch:
+		return "role mismatch"
+	case SignatureBad:
+		return "bad signature"
+	}
+
+	return fmt.Sprintf("unknown status %d", int(s))
+}
+
+// MarshalText encodes the status as its string representation, so it is readable in JSON and YAML
+// audit logs
+func (s SignatureStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// SignatureResult is the verification result of a single signature on an invoice
+type SignatureResult struct {
+	Signature Signature       `json:"signature" yaml:"signature"`
+	Status    SignatureStatus `json:"status" yaml:"status"`
+	// The keyring entry used to verify the signature. This is nil if no key was found for the
+	// signer
+	Key *SignatureKey `json:"key,omitempty" yaml:"key,omitempty"`
+	// The reason verification failed, nil if the signature is valid
+	Err error `json:"-" yaml:"-"`
+}
+
+// MarshalJSON encodes the result as JSON, including the failure reason as an `error` string
+func (r SignatureResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.withError())
+}
+
+// MarshalYAML encodes the result as YAML, including the failure reason as an `error` string
+func (r SignatureResult) MarshalYAML() (interface{}, error) {
+	return r.withError(), nil
+}
+
+// signatureResultWithError is a SignatureResult with the failure reason as a string. The alias type
+// doesn't have the marshaling methods, which avoids infinite recursion
+type signatureResultWithError struct {
+	signatureResult `yaml:",inline"`
+	Error           string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type signatureResult SignatureResult
+
+func (r SignatureResult) withError() signatureResultWithError {
+	out := signatureResultWithError{signatureResult: signatureResult(r)}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+	}
+
+	return out
+}
+
+// VerificationReport describes the outcome of verifying every signature on an invoice, suitable for
+// audit logs. Unlike `VerifySignatures`, all signatures are checked even after a failure
+type VerificationReport struct {
+	Strategy VerificationStrategy `json:"strategy" yaml:"strategy"`
+	Results  []SignatureResult    `json:"results" yaml:"results"`
+	// Whether the invoice passed verification according to the strategy
+	Passed bool `json:"passed" yaml:"passed"`
+}
+
+// VerificationReport verifies the signatures on the invoice using the signature keys provided and
+// returns a report with the result of each signature along with an overall decision according to
+// the given strategy. An error is only returned if one of the signature keys is invalid or the
+// strategy is unknown. Signatures are not checked for their age: the `at` timestamp is not part of
+// the signed cleartext (see `GenerateSignature`), so anyone can change it
+func (i *Invoice) VerificationReport(sigKeys []SignatureKey, strategy VerificationStrategy) (*VerificationReport, error) {
+	keys, err := newKeyIndex(sigKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerificationReport{
+		Strategy: strategy,
+		Results:  make([]SignatureResult, 0, len(i.Signature)),
+	}
+	for _, s := range i.Signature {
+		report.Results = append(report.Results, i.checkSignature(s, keys))
+	}
+
+	switch strategy {
+	case VerificationExhaustive:
+		// every signature must be valid
+		report.Passed = report.failure() == nil
+	default:
+		return nil, ErrInvalidVerificationStrategy
+	}
+
+	return report, nil
+}
+
+// Err returns nil if the invoice passed verification, otherwise an error describing the first
+// failed signature. The error wraps the reason for the failure (such as `ErrInvalidSignature`)
+func (r *VerificationReport) Err() error {
+	if r.Passed {
+		return nil
+	}
+
+	failed := r.failure()
+	if failed == nil {
+		return errors.New("verification failed")
+	}
+	return fmt.Errorf("%s signature by %q: %w", failed.Signature.Role, failed.Signature.By, failed.Err)
+}
+
+// Failed returns the results of all signatures that did not pass verification
+func (r *VerificationReport) Failed() []SignatureResult {
+	failed := []SignatureResult{}
+	for _, result := range r.Results {
+		if result.Status != SignatureValid {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+func (r *VerificationReport) failure() *SignatureResult {
+	for i := range r.Results {
+		if r.Results[i].Status != SignatureValid {
+			return &r.Results[i]
+		}
+	}
+
+	return nil
+}
+
+// checkSignature verifies the signature against each of its candidate keys. If none of them verify
+// it, the result describes the most relevant failure: a bad signature from a key trusted for the
+// role is reported over a key that isn't trusted for the role at all
+func (i *Invoice) checkSignature(s Signature, keys *keyIndex) SignatureResult {
+	result := SignatureResult{
+		Signature: s,
+		Status:    SignatureUnknownKey,
+		Err:       ErrMissingSignatureKey,
+	}
+
+	for _, key := range keys.candidates(s) {
+		err := i.verifySignature(s, key)
+		if err == nil {
+			result.Key = key
+			result.Status = SignatureValid
+			result.Err = nil
+			break
+		}
+
+		if errors.Is(err, ErrSignatureKeyRoleMismatch) {
+			if result.Status == SignatureUnknownKey {
+				result.Key = key
+				result.Status = SignatureRoleMismatch
+				result.Err = err
+			}
+			continue
+		}
+
+		result.Key = key
+		result.Status = SignatureBad
+		result.Err = err
+	}
+
+	return result
+}
@@ -0,0 +1,167 @@
+package types
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// InvoiceJSONSchema returns a JSON schema describing the JSON representation of an `Invoice`, which
+// can be used to validate invoices without any TOML tooling
+func InvoiceJSONSchema() ([]byte, error) {
+	return JSONSchema(Invoice{})
+}
+
+// JSONSchema generates a JSON schema (draft 7) for the JSON representation of the given value,
+// which is generally one of the types in this package. The schema is generated from the struct
+// fields and their `json` tags: fields without `omitempty` are required and every nested struct
+// type gets its own entry under `definitions`
+func JSONSchema(v interface{}) ([]byte, error) {
+	gen := schemaGenerator{definitions: map[string]interface{}{}}
+
+	schema, err := gen.schemaFor(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+
+	schema["$schema"] = jsonSchemaDraft
+	if len(gen.definitions) > 0 {
+		schema["definitions"] = gen.definitions
+	}
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+// schemaFor returns the schema for the given type. Nested structs are added to the definitions and
+// referenced, but the top level struct is returned inline
+func (g *schemaGenerator) schemaFor(t reflect.Type) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Struct {
+		return g.structSchema(t)
+	}
+
+	return g.typeSchema(t)
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if _, exists := g.definitions[t.Name()]; !exists {
+			// Reserve the name first so recursive types don't loop forever
+			g.definitions[t.Name()] = nil
+			schema, err := g.structSchema(t)
+			if err != nil {
+				return nil, err
+			}
+			g.definitions[t.Name()] = schema
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name, omitempty := parseJSONTag(field)
+		if name == "-" {
+			continue
+		}
+
+		schema, err := g.typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s.%s: %w", t.Name(), field.Name, err)
+		}
+		properties[name] = schema
+
+		if !omitempty && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if t.Name() != "" {
+		schema["title"] = t.Name()
+	}
+
+	return schema, nil
+}
+
+func parseJSONTag(field reflect.StructField) (name string, omitempty bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+
+	return name, omitempty
+}
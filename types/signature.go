@@ -4,6 +4,7 @@ import (
 	"crypto/ed25519"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 )
@@ -33,10 +34,42 @@ var ErrInvalidSignatureKey = errors.New("signature key is not valid")
 var ErrInvalidSignature = errors.New("signature is not valid")
 var ErrMissingSignatureKey = errors.New("missing signature key")
 var ErrInvalidVerificationStrategy = errors.New("invalid verification strategy")
+var ErrMissingHostSignature = errors.New("invoice does not have a host signature")
+var ErrMissingCreatorSignature = errors.New("invoice does not have a creator signature")
+
+// ValidateRoles checks that at least one role is given and that every role is one of the
+// `ValidRoles`. The returned error wraps `ErrInvalidRole`
+func ValidateRoles(roles []string) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("%w: at least one role is required", ErrInvalidRole)
+	}
+
+	for _, role := range roles {
+		if exists, val := ValidRoles[role]; !exists || !val {
+			return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+		}
+	}
+
+	return nil
+}
 
 // VerificationStrategy describes the type of signature validation performed
 type VerificationStrategy int
 
+func (v VerificationStrategy) String() string {
+	switch v {
+	case VerificationExhaustive:
+		return "exhaustive"
+	}
+
+	return fmt.Sprintf("unknown strategy %d", int(v))
+}
+
+// MarshalText encodes the strategy as its string representation
+func (v VerificationStrategy) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
 // Cleartext format:
 // Matt Butcher <matt.butcher@example.com>
 // mybindle
@@ -52,19 +85,37 @@ type VerificationStrategy int
 // Issue: https://github.com/deislabs/bindle/issues/284
 
 // GenerateSignature generates a signature for the provided role and author,
-// first validating that the given role is valid and the given author is included in the invoice
-// and then appends it to the invoice's signature list
+// first validating that the given role is valid and, for the creator role, that the given author is
+// included in the invoice and then appends it to the invoice's signature list
 func (i *Invoice) GenerateSignature(author, role string, sigKey *SignatureKey, privKey []byte) error {
+	signature, err := i.newSignature(author, role, sigKey, privKey)
+	if err != nil {
+		return err
+	}
+
+	if i.Signature == nil {
+		i.Signature = []Signature{}
+	}
+
+	i.Signature = append(i.Signature, *signature)
+
+	return nil
+}
+
+// newSignature validates the role and author and then signs the invoice's cleartext for them
+func (i *Invoice) newSignature(author, role string, sigKey *SignatureKey, privKey []byte) (*Signature, error) {
 	if exists, val := ValidRoles[role]; !exists || !val {
-		return ErrInvalidRole
+		return nil, ErrInvalidRole
 	}
 
 	if !sigKey.IncludesRole(role) {
-		return ErrSignatureKeyRoleMismatch
+		return nil, ErrSignatureKeyRoleMismatch
 	}
 
-	if !i.IsAuthoredBy(author) {
-		return ErrAuthorNotExist
+	// Per the spec, only creators have to be authors of the bindle. Approvers, proxies and hosts
+	// sign bindles they did not create
+	if role == RoleCreator && !i.IsAuthoredBy(author) {
+		return nil, ErrAuthorNotExist
 	}
 
 	timestamp := time.Now()
@@ -75,93 +126,178 @@ func (i *Invoice) GenerateSignature(author, role string, sigKey *SignatureKey, p
 
 	pubKey, err := base64.StdEncoding.DecodeString(sigKey.Key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	signature := Signature{
+	return &Signature{
 		By:        author,
 		Signature: base64.StdEncoding.EncodeToString(sig),
 		Key:       base64.StdEncoding.EncodeToString(pubKey),
 		Role:      role,
 		At:        timestamp.Unix(),
+	}, nil
+}
+
+// Approve adds an approver signature to an invoice that has already been signed by its creator(s).
+// Before approving, every creator signature on the invoice is verified using the given signature
+// keys, and at least one creator signature is required. Other signatures (such as host signatures
+// added by a server) are not checked. The approver does not need to be an author of the bindle
+func (i *Invoice) Approve(approver string, sigKey *SignatureKey, privKey []byte, sigKeys []SignatureKey) error {
+	creatorSigned := *i
+	creatorSigned.Signature = []Signature{}
+	for _, s := range i.Signature {
+		if s.Role == RoleCreator {
+			creatorSigned.Signature = append(creatorSigned.Signature, s)
+		}
 	}
 
-	if i.Signature == nil {
-		i.Signature = []Signature{}
+	if len(creatorSigned.Signature) == 0 {
+		return ErrMissingCreatorSignature
 	}
 
-	i.Signature = append(i.Signature, signature)
+	if err := creatorSigned.VerifySignatures(sigKeys, VerificationExhaustive); err != nil {
+		return err
+	}
 
-	return nil
+	return i.GenerateSignature(approver, RoleApprover, sigKey, privKey)
 }
 
 // VerifySignatures verifies the signatures on the invoice using the signature keys provided.
 // If any of the signatures were generated by keys not present in `sigKeys`, verification fails.
+// To find out which signatures failed verification and why, use `VerificationReport` instead
 func (i *Invoice) VerifySignatures(sigKeys []SignatureKey, strategy VerificationStrategy) error {
-	// map of author to key
-	keys := map[string]*SignatureKey{}
+	report, err := i.VerificationReport(sigKeys, strategy)
+	if err != nil {
+		return err
+	}
 
-	// first validate each key's signature and add them to a map
-	for i := range sigKeys {
-		key := sigKeys[i]
+	return report.Err()
+}
 
-		keyBytes, err := base64.StdEncoding.DecodeString(key.Key)
-		if err != nil {
-			return err
-		}
+// VerifyHostSignature verifies that the invoice carries a valid `host` signature made by one of the
+// provided host keys. Signatures with any other role are ignored. Returns
+// `ErrMissingHostSignature` if the invoice has no host signature at all
+func (i *Invoice) VerifyHostSignature(hostKeys []SignatureKey) error {
+	keys, err := newKeyIndex(hostKeys)
+	if err != nil {
+		return err
+	}
 
-		labelSigBytes, err := base64.StdEncoding.DecodeString(key.LabelSignature)
-		if err != nil {
-			return err
+	// keep the first failure around so the caller knows why none of the host signatures matched
+	var verifyErr error
+	for _, s := range i.Signature {
+		if s.Role != RoleHost {
+			continue
 		}
 
-		if valid := ed25519.Verify(keyBytes, []byte(key.Label), labelSigBytes); !valid {
-			return ErrInvalidSignatureKey
+		result := i.checkSignature(s, keys)
+		if result.Status != SignatureValid {
+			verifyErr = result.Err
+			continue
 		}
 
-		keys[key.Label] = &key
+		return nil
 	}
 
-	switch strategy {
-	case VerificationExhaustive:
-		return i.exhaustiveVerification(keys)
+	if verifyErr != nil {
+		return verifyErr
 	}
 
-	return ErrInvalidVerificationStrategy
+	return ErrMissingHostSignature
 }
 
-func (i *Invoice) exhaustiveVerification(keys map[string]*SignatureKey) error {
-	// then validate each of the invoice's signatures using the keys we have available
-	for _, s := range i.Signature {
-		key := keys[s.By]
-		if key == nil {
-			return ErrMissingSignatureKey
-		}
+// keyIndex looks up the trusted keys that can be used to verify a signature. A label can have any
+// number of keys, for example when an author signs from multiple machines or has rotated their key
+type keyIndex struct {
+	byLabel map[string][]*SignatureKey
+}
 
-		if !key.IncludesRole(s.Role) {
-			return ErrSignatureKeyRoleMismatch
-		}
+// newKeyIndex validates each key's label signature and indexes the keys by label
+func newKeyIndex(sigKeys []SignatureKey) (*keyIndex, error) {
+	index := &keyIndex{byLabel: map[string][]*SignatureKey{}}
 
-		keyBytes, err := base64.StdEncoding.DecodeString(key.Key)
-		if err != nil {
-			return err
-		}
+	for i := range sigKeys {
+		key := sigKeys[i]
 
-		sigBytes, err := base64.StdEncoding.DecodeString(s.Signature)
-		if err != nil {
-			return err
+		if err := key.VerifyLabel(); err != nil {
+			return nil, err
 		}
 
-		cleartext := []byte(i.generateCleartext(key.Label, s.Role))
+		index.byLabel[key.Label] = append(index.byLabel[key.Label], &key)
+	}
+
+	return index, nil
+}
 
-		if valid := ed25519.Verify(keyBytes, cleartext, sigBytes); !valid {
-			return ErrInvalidSignature
+// candidates returns the keys that could have made the given signature. If the signature includes
+// the public key that made it, only the entry for that exact key is returned, otherwise all keys for
+// the signer are candidates
+func (k *keyIndex) candidates(s Signature) []*SignatureKey {
+	keys := k.byLabel[s.By]
+	if s.Key == "" {
+		return keys
+	}
+
+	for _, key := range keys {
+		if key.Key == s.Key {
+			return []*SignatureKey{key}
 		}
 	}
 
 	return nil
 }
 
+// verifySignature checks a single signature on the invoice against the given key
+func (i *Invoice) verifySignature(s Signature, key *SignatureKey) error {
+	if !key.IncludesRole(s.Role) {
+		return ErrSignatureKeyRoleMismatch
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(key.Key)
+	if err != nil {
+		return err
+	}
+	if len(keyBytes) != ed25519.PublicKeySize {
+		return ErrInvalidSignatureKey
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return err
+	}
+
+	cleartext := []byte(i.generateCleartext(key.Label, s.Role))
+
+	if valid := ed25519.Verify(keyBytes, cleartext, sigBytes); !valid {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// VerifyLabel checks that the key's label signature was made by the key itself
+func (s *SignatureKey) VerifyLabel() error {
+	keyBytes, err := base64.StdEncoding.DecodeString(s.Key)
+	if err != nil {
+		return err
+	}
+	// ed25519.Verify panics on keys of the wrong length
+	if len(keyBytes) != ed25519.PublicKeySize {
+		return ErrInvalidSignatureKey
+	}
+
+	labelSigBytes, err := base64.StdEncoding.DecodeString(s.LabelSignature)
+	if err != nil {
+		return err
+	}
+
+	if valid := ed25519.Verify(keyBytes, []byte(s.Label), labelSigBytes); !valid {
+		return ErrInvalidSignatureKey
+	}
+
+	return nil
+}
+
 // IsAuthoredBy returns true if the provided author is in the
 // list of authors for this invoice
 func (i *Invoice) IsAuthoredBy(author string) bool {
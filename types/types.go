@@ -3,6 +3,7 @@ package types
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -13,13 +14,13 @@ import (
 // Most fields on this struct are singular to best represent the specification. There, fields like
 // `group` and `parcel` are singular due to the conventions of TOML.
 type Invoice struct {
-	BindleVersion string            `toml:"bindleVersion"`
-	Yanked        *bool             `toml:"yanked"`
-	Bindle        BindleSpec        `toml:"bindle"`
-	Annotations   map[string]string `toml:"annotations,omitempty"`
-	Signature     []Signature       `toml:"signature,omitempty"`
-	Parcel        []Parcel          `toml:"parcel,omitempty"`
-	Group         []Group           `toml:"group,omitempty"`
+	BindleVersion string            `toml:"bindleVersion" json:"bindleVersion" yaml:"bindleVersion"`
+	Yanked        *bool             `toml:"yanked" json:"yanked,omitempty" yaml:"yanked,omitempty"`
+	Bindle        BindleSpec        `toml:"bindle" json:"bindle" yaml:"bindle"`
+	Annotations   map[string]string `toml:"annotations,omitempty" json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Signature     []Signature       `toml:"signature,omitempty" json:"signature,omitempty" yaml:"signature,omitempty"`
+	Parcel        []Parcel          `toml:"parcel,omitempty" json:"parcel,omitempty" yaml:"parcel,omitempty"`
+	Group         []Group           `toml:"group,omitempty" json:"group,omitempty" yaml:"group,omitempty"`
 }
 
 // Name returns the full name of the bindle (name + version)
@@ -32,10 +33,10 @@ func (i Invoice) Name() string {
 
 // BindleSpec contains the data to identify a bindle as well as additional metadata describing it
 type BindleSpec struct {
-	Name        string   `toml:"name"`
-	Version     string   `toml:"version"`
-	Description *string  `toml:"description"`
-	Authors     []string `toml:"authors,omitempty"`
+	Name        string   `toml:"name" json:"name" yaml:"name"`
+	Version     string   `toml:"version" json:"version" yaml:"version"`
+	Description *string  `toml:"description" json:"description,omitempty" yaml:"description,omitempty"`
+	Authors     []string `toml:"authors,omitempty" json:"authors,omitempty" yaml:"authors,omitempty"`
 }
 
 // Parcel is a description of a stored parcel file. A parcel file can be an arbitrary "blob" of
@@ -43,57 +44,57 @@ type BindleSpec struct {
 // conditions for using a parcel. For more information, see the Bindle Spec:
 // https://github.com/deislabs/bindle/blob/master/docs/bindle-spec.md
 type Parcel struct {
-	Label      Label      `toml:"label"`
-	Conditions *Condition `toml:"conditions"`
+	Label      Label      `toml:"label" json:"label" yaml:"label"`
+	Conditions *Condition `toml:"conditions" json:"conditions,omitempty" yaml:"conditions,omitempty"`
 }
 
 // Label is the metadata of a stored parcel. See the Label Spec for more detailed information:
 // https://github.com/deislabs/bindle/blob/master/docs/label-spec.md
 type Label struct {
-	SHA256      string                       `toml:"sha256"`
-	MediaType   string                       `toml:"mediaType"`
-	Name        string                       `toml:"name"`
-	Size        uint64                       `toml:"size"`
-	Annotations map[string]string            `toml:"annotations,omitempty"`
-	Feature     map[string]map[string]string `toml:"feature,omitempty"`
+	SHA256      string                       `toml:"sha256" json:"sha256" yaml:"sha256"`
+	MediaType   string                       `toml:"mediaType" json:"mediaType" yaml:"mediaType"`
+	Name        string                       `toml:"name" json:"name" yaml:"name"`
+	Size        uint64                       `toml:"size" json:"size" yaml:"size"`
+	Annotations map[string]string            `toml:"annotations,omitempty" json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Feature     map[string]map[string]string `toml:"feature,omitempty" json:"feature,omitempty" yaml:"feature,omitempty"`
 }
 
-/// Condition is used to associate parcels to `Group`s
+// Condition is used to associate parcels to `Group`s
 type Condition struct {
-	MemberOf []string `toml:"memberOf,omitempty"`
-	Requires []string `toml:"requires,omitempty"`
+	MemberOf []string `toml:"memberOf,omitempty" json:"memberOf,omitempty" yaml:"memberOf,omitempty"`
+	Requires []string `toml:"requires,omitempty" json:"requires,omitempty" yaml:"requires,omitempty"`
 }
 
 // Group is a top-level organization object that may contain zero or more parcels. Every parcel
 // belongs to at least one group, but may belong to others.
 type Group struct {
-	Name        string  `toml:"name"`
-	Required    *bool   `toml:"required"`
-	SatisfiedBy *string `toml:"satisfiedBy"`
+	Name        string  `toml:"name" json:"name" yaml:"name"`
+	Required    *bool   `toml:"required" json:"required,omitempty" yaml:"required,omitempty"`
+	SatisfiedBy *string `toml:"satisfiedBy" json:"satisfiedBy,omitempty" yaml:"satisfiedBy,omitempty"`
 }
 
 // Signature is a (default Ed25519) signature of the bindle based on the spec:
 // https://github.com/deislabs/bindle/blob/main/docs/signing-spec.md
 type Signature struct {
-	By        string `toml:"by"`
-	Signature string `toml:"signature"`
-	Key       string `toml:"key"`
-	Role      string `toml:"role"`
-	At        int64  `toml:"at"`
+	By        string `toml:"by" json:"by" yaml:"by"`
+	Signature string `toml:"signature" json:"signature" yaml:"signature"`
+	Key       string `toml:"key" json:"key" yaml:"key"`
+	Role      string `toml:"role" json:"role" yaml:"role"`
+	At        int64  `toml:"at" json:"at" yaml:"at"`
 }
 
 // Keyring represents a list of available public keys for signature validation
 type Keyring struct {
-	Version string         `toml:"version"`
-	Key     []SignatureKey `toml:"key"`
+	Version string         `toml:"version" json:"version" yaml:"version"`
+	Key     []SignatureKey `toml:"key" json:"key" yaml:"key"`
 }
 
 // SignatureKey is a representation of a public key used to validate signatures
 type SignatureKey struct {
-	Label          string   `toml:"label"`
-	Roles          []string `toml:"roles"`
-	Key            string   `toml:"key"`
-	LabelSignature string   `toml:"labelSignature"`
+	Label          string   `toml:"label" json:"label" yaml:"label"`
+	Roles          []string `toml:"roles" json:"roles" yaml:"roles"`
+	Key            string   `toml:"key" json:"key" yaml:"key"`
+	LabelSignature string   `toml:"labelSignature" json:"labelSignature" yaml:"labelSignature"`
 }
 
 // IncludesRole returns true if the SignatureKey includes the given role
@@ -110,29 +111,29 @@ func (s *SignatureKey) IncludesRole(role string) bool {
 // InvoiceCreateResponse is returned by a Bindle server when creating an invoice. It contains the
 // created invoice and an optional slice of labels indicating which parcels are missing in storage
 type InvoiceCreateResponse struct {
-	Invoice Invoice `toml:"invoice"`
-	Missing []Label `toml:"missing,omitempty"`
+	Invoice Invoice `toml:"invoice" json:"invoice" yaml:"invoice"`
+	Missing []Label `toml:"missing,omitempty" json:"missing,omitempty" yaml:"missing,omitempty"`
 }
 
 // MissingParcelsResponse is a response to a missing parcels request. TOML doesn't support top level arrays, so they
 // must be embedded in a table
 type MissingParcelsResponse struct {
-	Missing []Label `toml:"missing"`
+	Missing []Label `toml:"missing" json:"missing" yaml:"missing"`
 }
 
 // ErrorResponse is a string error message returned from the server
 type ErrorResponse struct {
-	Error string `toml:"error"`
+	Error string `toml:"error" json:"error" yaml:"error"`
 }
 
 // QueryOptions represents available options for the query API
 type QueryOptions struct {
-	Query   *string `toml:"q"`
-	Version *string `toml:"v"`
-	Offset  *uint64 `toml:"o"`
-	Limit   *uint8  `toml:"l"`
-	Strict  *bool   `toml:"strict"`
-	Yanked  *bool   `toml:"yanked"`
+	Query   *string `toml:"q" json:"q,omitempty" yaml:"q,omitempty"`
+	Version *string `toml:"v" json:"v,omitempty" yaml:"v,omitempty"`
+	Offset  *uint64 `toml:"o" json:"o,omitempty" yaml:"o,omitempty"`
+	Limit   *uint8  `toml:"l" json:"l,omitempty" yaml:"l,omitempty"`
+	Strict  *bool   `toml:"strict" json:"strict,omitempty" yaml:"strict,omitempty"`
+	Yanked  *bool   `toml:"yanked" json:"yanked,omitempty" yaml:"yanked,omitempty"`
 }
 
 // QueryString returns a query string suitable for use in a URL (including the starting `?`) using
@@ -140,22 +141,22 @@ type QueryOptions struct {
 func (q *QueryOptions) QueryString() string {
 	var pairs []string
 	if q.Query != nil {
-		pairs = append(pairs, fmt.Sprintf("q=%s", *q.Query))
+		pairs = append(pairs, fmt.Sprintf("q=%s", url.QueryEscape(*q.Query)))
 	}
 	if q.Version != nil {
-		pairs = append(pairs, fmt.Sprintf("v=%s", *q.Version))
+		pairs = append(pairs, fmt.Sprintf("v=%s", url.QueryEscape(*q.Version)))
 	}
 	if q.Offset != nil {
-		pairs = append(pairs, fmt.Sprintf("o=%d", q.Offset))
+		pairs = append(pairs, fmt.Sprintf("o=%d", *q.Offset))
 	}
 	if q.Limit != nil {
-		pairs = append(pairs, fmt.Sprintf("l=%d", q.Limit))
+		pairs = append(pairs, fmt.Sprintf("l=%d", *q.Limit))
 	}
 	if q.Strict != nil {
-		pairs = append(pairs, fmt.Sprintf("strict=%v", q.Strict))
+		pairs = append(pairs, fmt.Sprintf("strict=%v", *q.Strict))
 	}
 	if q.Yanked != nil {
-		pairs = append(pairs, fmt.Sprintf("yanked=%v", q.Yanked))
+		pairs = append(pairs, fmt.Sprintf("yanked=%v", *q.Yanked))
 	}
 
 	return "?" + strings.Join(pairs, "&")
@@ -164,23 +165,23 @@ func (q *QueryOptions) QueryString() string {
 // Matches describes the matches that are returned from a query
 type Matches struct {
 	// The query used to find this match set
-	Query string `toml:"query"`
+	Query string `toml:"query" json:"query" yaml:"query"`
 	// Whether the search engine used strict mode
-	Strict bool `toml:"strict"`
+	Strict bool `toml:"strict" json:"strict" yaml:"strict"`
 	// The offset of the first result in the matches
-	Offset uint64 `toml:"offset"`
+	Offset uint64 `toml:"offset" json:"offset" yaml:"offset"`
 	// The maximum number of results this query would have returned
-	Limit uint8 `toml:"limit"`
+	Limit uint8 `toml:"limit" json:"limit" yaml:"limit"`
 	// The total number of matches the search engine located
 	//
 	// In many cases, this will not match the number of results returned on this query
-	Total uint64 `toml:"total"`
+	Total uint64 `toml:"total" json:"total" yaml:"total"`
 	// Whether there are more results than the ones returned here
-	More bool `toml:"more"`
+	More bool `toml:"more" json:"more" yaml:"more"`
 	// Whether this list includes potentially yanked invoices
-	Yanked bool `toml:"yanked"`
+	Yanked bool `toml:"yanked" json:"yanked" yaml:"yanked"`
 	// The list of invoices returned as this part of the query
 	//
 	// The length of this Vec will be less than or equal to the limit.
-	Invoices []Invoice `toml:"invoices"`
+	Invoices []Invoice `toml:"invoices" json:"invoices" yaml:"invoices"`
 }